@@ -0,0 +1,414 @@
+package cache
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bundleIndexName はバンドルの目録のファイル名です.
+const bundleIndexName = "bundle.json"
+
+// bundleVersion はバンドルの形式のバージョンです.
+const bundleVersion = 1
+
+// BundleFormat はバンドルのアーカイブ形式です.
+type BundleFormat int
+
+const (
+	TarGz BundleFormat = iota // tar.gz 形式
+	Zip                       // zip 形式
+)
+
+var (
+	errBundleFormat    = errors.New("invalid bundle format")
+	errBundleIntegrity = errors.New("bundle integrity check failed")
+)
+
+// bundleIndex はバンドルの目録を表します.
+type bundleIndex struct {
+	Version int               `json:"version"`
+	Trans   []*Tx             `json:"transactions"`
+	Files   map[string]string `json:"files"` // トランザクション名/エントリ名 と SHA-256
+}
+
+// ExportBundle はトランザクション names を 1 つのアーカイブにまとめて w に出力します.
+//
+// バンドルには管理情報とキャッシュファイル そのチェックサムが含まれ
+// 別のキャッシュディレクトリに ImportBundle で取り込むことができます.
+// フォークしたトランザクションはフォーク元も合わせて出力します.
+//
+// 出力する間はトランザクションを使用中にして破棄されないようにします.
+// 記録中のトランザクションも出力できますが 出力を始めた後に保存されたエントリは含まれないことがあります.
+func (c *Cache) ExportBundle(w io.Writer, format BundleFormat, names ...string) error {
+	var txs []*Tx
+	var unlocks []func() error
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+	err := c.view(func() error {
+		seen := make(map[string]bool)
+		for _, name := range names {
+			tx := c.findTx(name)
+			if tx == nil {
+				return fmt.Errorf("export transaction %q: %w", name, errNoSuchTx)
+			}
+			for ; tx != nil && !seen[tx.Name]; tx = tx.base {
+				seen[tx.Name] = true
+				if l, ok := c.st.(Locker); ok && !c.ro {
+					unlock, err := l.LockTx(tx.Name)
+					if err != nil {
+						return err
+					}
+					unlocks = append(unlocks, unlock)
+				}
+				// 管理情報は出力中に他から更新されないよう複製しておく
+				cp := *tx
+				txs = append(txs, &cp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 目録を先頭に置くため キャッシュファイルを一時ファイルに複製しながらチェックサムを計算する
+	// 出力中にエントリが追記されても 目録と出力する内容は一致する
+	spool, err := os.CreateTemp("", "crawlb-bundle-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	type part struct {
+		file      string
+		off, size int64
+	}
+	var parts []part
+	var off int64
+	idx := &bundleIndex{Version: bundleVersion, Trans: txs, Files: make(map[string]string)}
+	for _, tx := range txs {
+		infos, err := tx.st.List(tx.Name)
+		if isNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			r, err := tx.st.Get(tx.Name, info.Name)
+			if isNotExist(err) {
+				// マニフェストの書き直しなどで一覧を取得した後に削除されたもの
+				continue
+			} else if err != nil {
+				return err
+			}
+			dw := newDigestWriter()
+			n, err := io.Copy(io.MultiWriter(spool, dw), r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			file := tx.Name + "/" + info.Name
+			idx.Files[file] = dw.digest()
+			parts = append(parts, part{file, off, n})
+			off += n
+		}
+	}
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if err = aw.add(bundleIndexName, int64(len(b)), bytes.NewReader(b)); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err = aw.add(p.file, p.size, io.NewSectionReader(spool, p.off, p.size)); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// ImportBundle は ExportBundle が出力したバンドルをキャッシュに取り込み
+// 取り込んだトランザクションの名前を返します.
+//
+// アーカイブ形式は内容から判別します.
+// 同じ名前のトランザクションが既にある場合は名前に連番を付けて取り込みます.
+// キャッシュファイルがチェックサムと一致しない場合は何も取り込まずにエラーを返します.
+//
+// 取り込んだトランザクションは作成日時を引き継ぐため 保持ルールによって破棄されることがあります.
+// 残しておく場合は Pin で固定してください.
+func (c *Cache) ImportBundle(r io.Reader) ([]string, error) {
+	ar, err := newArchiveReader(r)
+	if err != nil {
+		return nil, err
+	}
+	name, rc, err := ar.next()
+	if err != nil {
+		return nil, err
+	}
+	var idx bundleIndex
+	if name != bundleIndexName {
+		return nil, fmt.Errorf("import bundle: %w", errBundleFormat)
+	}
+	err = json.NewDecoder(rc).Decode(&idx)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if idx.Version != bundleVersion {
+		return nil, fmt.Errorf("import bundle version %d: %w", idx.Version, errBundleFormat)
+	}
+
+	var imported []string
+	err = c.update(func() error {
+		// 管理ファイルに無いディレクトリも含めて衝突しないトランザクション名を決める
+		// 取り込みに失敗した場合に削除するため 既存のディレクトリには書き込まない
+		existing, err := c.st.ListTx()
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+		rename := make(map[string]string)
+		for _, tx := range idx.Trans {
+			if !validTxName(tx.Name) {
+				return fmt.Errorf("import transaction %q: %w", tx.Name, errInvalidTx)
+			}
+			newname := tx.Name
+			for i := 2; c.findTx(newname) != nil || contains(existing, newname) || contains(imported, newname); i++ {
+				newname = tx.Name + "-" + strconv.Itoa(i)
+			}
+			rename[tx.Name] = newname
+			imported = append(imported, newname)
+		}
+
+		if err := c.importFiles(ar, &idx, rename); err != nil {
+			for _, name := range imported {
+				c.st.DeleteTx(name)
+			}
+			return err
+		}
+
+		for _, tx := range idx.Trans {
+			tx.Name = rename[tx.Name]
+			if tx.Base != "" {
+				tx.Base = rename[tx.Base]
+			}
+			tx.txState = &txState{c: c, st: c.st}
+			c.Trans = append(c.Trans, tx)
+		}
+		sort.SliceStable(c.Trans, func(i, j int) bool {
+			return c.Trans[i].CreateAt > c.Trans[j].CreateAt
+		})
+		for _, tx := range idx.Trans {
+			tx.base = c.findTx(tx.Base)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// importFiles はバンドルのキャッシュファイルをチェックサムを確認しながら保存します.
+func (c *Cache) importFiles(ar archiveReader, idx *bundleIndex, rename map[string]string) error {
+	done := make(map[string]bool)
+	for {
+		file, rc, err := ar.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		want, ok := idx.Files[file]
+		txname, name := path.Split(file)
+		newname := rename[strings.TrimSuffix(txname, "/")]
+		if !ok || newname == "" || !isBundleFile(name) || done[file] {
+			rc.Close()
+			return fmt.Errorf("import %s: %w", file, errBundleIntegrity)
+		}
+		dw := newDigestWriter()
+		err = c.st.Put(newname, name, io.TeeReader(rc, dw))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if dw.digest() != want {
+			return fmt.Errorf("import %s: %w", file, errBundleIntegrity)
+		}
+		done[file] = true
+	}
+	for file := range idx.Files {
+		if !done[file] {
+			return fmt.Errorf("import %s: missing: %w", file, errBundleIntegrity)
+		}
+	}
+	return nil
+}
+
+// isBundleFile はバンドルからトランザクションに取り込めるファイル名かを返します.
+//
+// トランザクションのディレクトリの外に書き込まれないよう キャッシュファイルとマニフェスト以外は拒否します.
+func isBundleFile(name string) bool {
+	return isEntryName(name) || name == manifestName || name == manifestLog
+}
+
+// contains は s に v が含まれるかを返します.
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveWriter はバンドルのアーカイブを出力します.
+type archiveWriter interface {
+	add(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+// newArchiveWriter は format の archiveWriter を作成します.
+func newArchiveWriter(w io.Writer, format BundleFormat) (archiveWriter, error) {
+	switch format {
+	case TarGz:
+		zw := gzip.NewWriter(w)
+		return &tarWriter{tar.NewWriter(zw), zw}, nil
+	case Zip:
+		return &zipWriter{zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("bundle format %d: %w", format, errBundleFormat)
+}
+
+// tarWriter は tar.gz 形式の archiveWriter です.
+type tarWriter struct {
+	tw *tar.Writer
+	zw *gzip.Writer
+}
+
+func (a *tarWriter) add(name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.zw.Close()
+}
+
+// zipWriter は zip 形式の archiveWriter です.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipWriter) add(name string, size int64, r io.Reader) error {
+	w, err := a.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipWriter) Close() error {
+	return a.zw.Close()
+}
+
+// archiveReader はバンドルのアーカイブからファイルを順に読み込みます.
+type archiveReader interface {
+	// next は次のファイルを返します. ファイルが無い場合は io.EOF を返します.
+	next() (name string, r io.ReadCloser, err error)
+}
+
+// newArchiveReader は内容から形式を判別して archiveReader を作成します.
+func newArchiveReader(r io.Reader) (archiveReader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &tarReader{tar.NewReader(zr)}, nil
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		// zip は末尾の目録から読むため全体を読み込む
+		b, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+		return &zipReader{files: zr.File}, nil
+	}
+	return nil, fmt.Errorf("import bundle: %w", errBundleFormat)
+}
+
+// tarReader は tar.gz 形式の archiveReader です.
+type tarReader struct {
+	tr *tar.Reader
+}
+
+func (a *tarReader) next() (string, io.ReadCloser, error) {
+	for {
+		hdr, err := a.tr.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return hdr.Name, io.NopCloser(a.tr), nil
+		}
+	}
+}
+
+// zipReader は zip 形式の archiveReader です.
+type zipReader struct {
+	files []*zip.File
+}
+
+func (a *zipReader) next() (string, io.ReadCloser, error) {
+	for len(a.files) > 0 {
+		f := a.files[0]
+		a.files = a.files[1:]
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		return f.Name, r, nil
+	}
+	return "", nil, io.EOF
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	src := NewMemory(10)
+	base, err := src.NewTransaction(WithName("base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := http.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	b, _ := http.NewRequest(http.MethodGet, "https://example.com/b", nil)
+	storeResponse(t, base, a, http.StatusOK, "base a")
+	if err = base.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	fork, err := src.Fork("base", WithName("fork"), WithNote("failing run"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeResponse(t, fork, b, http.StatusNotFound, "fork b")
+
+	for _, format := range []BundleFormat{TarGz, Zip} {
+		var buf bytes.Buffer
+		if err = src.ExportBundle(&buf, format, "fork"); err != nil {
+			t.Fatal(err)
+		}
+
+		// 同じ名前のトランザクションがある場合は名前を変えて取り込むこと
+		dst := NewMemory(10)
+		tx, err := dst.NewTransaction(WithName("base"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Release()
+		names, err := dst.ImportBundle(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"fork", "base-2"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("%s = %v, want %v", "imported", names, want)
+		}
+		got, err := dst.GetTransaction("fork")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Base != "base-2" || got.Note != "failing run" {
+			t.Errorf("%s = %q, %q, want %q, %q", "fork", got.Base, got.Note, "base-2", "failing run")
+		}
+		if body := loadBody(t, got, a); body != "base a" {
+			t.Errorf("%s = %q, want %q", "body", body, "base a")
+		}
+		if body := loadBody(t, got, b); body != "fork b" {
+			t.Errorf("%s = %q, want %q", "body", body, "fork b")
+		}
+	}
+
+	// 改ざんされたバンドルは取り込まないこと
+	var buf bytes.Buffer
+	if err = src.ExportBundle(&buf, Zip, "base"); err != nil {
+		t.Fatal(err)
+	}
+	ar, err := newArchiveReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var broken bytes.Buffer
+	aw, _ := newArchiveWriter(&broken, TarGz)
+	for {
+		name, r, err := ar.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		b = bytes.Replace(b, []byte("base a"), []byte("evil a"), 1)
+		aw.add(name, int64(len(b)), bytes.NewReader(b))
+	}
+	aw.Close()
+	dst := NewMemory(10)
+	if _, err = dst.ImportBundle(&broken); err == nil {
+		t.Errorf("%s = %v, want error", "ImportBundle", err)
+	}
+	if txs, _ := dst.Find(); len(txs) != 0 {
+		t.Errorf("%s = %d, want %d", "len(transactions)", len(txs), 0)
+	}
+	if err = src.ExportBundle(&buf, Zip, "none"); !errors.Is(err, errNoSuchTx) {
+		t.Errorf("%s = %v, want %v", "ExportBundle", err, errNoSuchTx)
+	}
+}
+
+func TestBundleUnsafe(t *testing.T) {
+	// tamper が true の場合はチェックサムと一致しない内容を書き込む
+	bundle := func(files map[string]string, tamper bool) *bytes.Buffer {
+		idx := &bundleIndex{Version: bundleVersion, Trans: []*Tx{{Name: "base"}}, Files: make(map[string]string)}
+		for file, body := range files {
+			dw := newDigestWriter()
+			dw.Write([]byte(body))
+			idx.Files[file] = dw.digest()
+		}
+		b, _ := json.Marshal(idx)
+		var buf bytes.Buffer
+		aw, _ := newArchiveWriter(&buf, Zip)
+		aw.add(bundleIndexName, int64(len(b)), bytes.NewReader(b))
+		for file, body := range files {
+			if tamper {
+				body += "!"
+			}
+			aw.add(file, int64(len(body)), strings.NewReader(body))
+		}
+		aw.Close()
+		return &buf
+	}
+
+	// トランザクションの外を指すファイル名は取り込まないこと
+	for _, name := range []string{`..\..\x`, "..", ".hidden", "x"} {
+		dst := NewMemory(10)
+		_, err := dst.ImportBundle(bundle(map[string]string{"base/" + name: "evil"}, false))
+		if !errors.Is(err, errBundleIntegrity) {
+			t.Errorf("%s(%q) = %v, want %v", "ImportBundle", name, err, errBundleIntegrity)
+		}
+	}
+
+	// 管理ファイルに無いディレクトリに書き込まず 失敗しても削除しないこと
+	dst := NewMemory(10)
+	dst.st.Put("base", "orphan", strings.NewReader("orphan"))
+	entry := "base/" + strings.Repeat("0", 32)
+	names, err := dst.ImportBundle(bundle(map[string]string{entry: "ok"}, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"base-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("%s = %v, want %v", "imported", names, want)
+	}
+	dst.st.DeleteTx("base-2")
+	if _, err = dst.ImportBundle(bundle(map[string]string{entry: "ok"}, true)); !errors.Is(err, errBundleIntegrity) {
+		t.Errorf("%s = %v, want %v", "ImportBundle", err, errBundleIntegrity)
+	}
+	if _, err = dst.st.Stat("base", "orphan"); err != nil {
+		t.Errorf("%s = %v, want nil", "orphan", err)
+	}
+}
+
+func TestBundleWhileStoring(t *testing.T) {
+	// 記録中のトランザクションを出力しても 目録と内容が一致すること
+	src, err := NewWithStorage(NewDirStorage(t.TempDir()), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := src.NewTransaction(WithName("open"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, done := make(chan struct{}), make(chan error)
+	go func() {
+		for i := 0; i < 500; i++ {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/"+strconv.Itoa(i), nil)
+			cf, err := tx.NewFile(req)
+			if err == nil {
+				err = cf.Store(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(strings.Repeat("x", i)))})
+			}
+			if err != nil {
+				<-stop
+				done <- err
+				return
+			}
+		}
+		<-stop
+		done <- nil
+	}()
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if err = src.ExportBundle(&buf, TarGz, "open"); err != nil {
+			t.Error(err)
+			break
+		}
+		if _, err = NewMemory(10).ImportBundle(&buf); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(stop)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -11,134 +11,580 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"path"
+	"reflect"
 	"strconv"
-	"syscall"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/17e10/go-jsonb"
 )
 
 // ctlname はキャッシュの管理ファイル名です.
 const ctlname = "cache.json"
 
+// createAtLayout はトランザクションの作成日時の書式です.
+const createAtLayout = "2006-01-02 15:04:05.000"
+
 var (
-	errNoSuchTx = errors.New("no such transaction")
+	errNoSuchTx  = errors.New("no such transaction")
+	errTxExists  = errors.New("transaction already exists")
+	errInvalidTx = errors.New("invalid transaction name")
+	errReadOnly  = errors.New("cache is read-only")
 )
 
 // Cache は http のキャッシュ機構を提供します.
+//
+// 管理ファイルを更新する操作は保存先が Locker を実装していればプロセス間で排他制御され
+// 他のプロセスが作成したトランザクションを読み込んでから更新します.
 type Cache struct {
-	dir   string
-	numTx int
-	Trans []Tx `json:"transactions"`
+	mu     sync.Mutex
+	st     Storage
+	numTx  int
+	rules  []Rule
+	redact *Redaction
+	ro     bool  // 読み取り専用で開いた
+	Trans  []*Tx `json:"transactions"`
 }
 
-// New は新しい Cache を作成します.
+// New はディレクトリ dir に保存する新しい Cache を作成します.
 func New(dir string, numTx int) (*Cache, error) {
-	c := &Cache{dir: dir, numTx: numTx}
+	return NewWithStorage(NewDirStorage(dir), numTx)
+}
+
+// NewWithStorage は st に保存する新しい Cache を作成します.
+func NewWithStorage(st Storage, numTx int) (*Cache, error) {
+	return newCache(&Cache{st: st, numTx: numTx})
+}
+
+// NewWithRetention は st に保存し rules を保持ルールとする新しい Cache を作成します.
+//
+// 作成時の破棄にも rules を適用するため 既定の KeepGenerations で
+// 残したいトランザクションを破棄してしまうことがありません.
+// rules を指定しない場合はトランザクションを破棄しません.
+func NewWithRetention(st Storage, rules ...Rule) (*Cache, error) {
+	return newCache(&Cache{st: st, rules: append([]Rule{}, rules...)})
+}
 
-	err := c.loadCtlFile()
-	if err != nil && errors.Unwrap(err) != syscall.ENOENT {
+// newCache は c の保持ルールに従って古いトランザクションを破棄してから c を返します.
+func newCache(c *Cache) (*Cache, error) {
+	if err := c.update(c.discardAll); err != nil {
 		return nil, err
 	}
-	if err = c.discard(); err != nil {
+	return c, nil
+}
+
+// OpenReadOnly は st に保存されたキャッシュを読み取り専用で開きます.
+//
+// 管理ファイルやマニフェストを読み込むだけで 古いトランザクションの破棄や管理ファイルの保存
+// ロックファイルの作成など保存先への書き込みは一切行いません.
+// トランザクションの作成や変更 キャッシュファイルの保存はエラーになります.
+// 使用中のロックも取得しないため 読み込んでいる間に他のプロセスが
+// トランザクションを破棄した場合は読み込みに失敗することがあります.
+func OpenReadOnly(st Storage) (*Cache, error) {
+	c := &Cache{st: st, ro: true}
+	if err := c.view(func() error { return nil }); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-// 新しいトランザクションを作成します.
-func (c *Cache) NewTransaction() (*Tx, error) {
-	var zero Tx
+// NewMemory はメモリ上に保存する新しい Cache を作成します.
+//
+// ファイルシステムを使用しないため ユニットテストで使い捨てのキャッシュとして利用できます.
+func NewMemory(numTx int) *Cache {
+	return &Cache{st: NewMemStorage(), numTx: numTx}
+}
+
+// Storage は Cache の保存先を返します.
+func (c *Cache) Storage() Storage {
+	return c.st
+}
+
+// TxOption は NewTransaction に渡すオプションです.
+type TxOption func(tx *Tx)
+
+// WithName はトランザクション名を指定します.
+//
+// 指定しない場合は作成時刻からトランザクション名を作成します.
+func WithName(name string) TxOption {
+	return func(tx *Tx) {
+		tx.Name = name
+	}
+}
+
+// WithLabels はトランザクションにラベルを付けます.
+func WithLabels(labels ...string) TxOption {
+	return func(tx *Tx) {
+		tx.Labels = append(tx.Labels, labels...)
+	}
+}
+
+// WithNote はトランザクションにメモを付けます.
+func WithNote(note string) TxOption {
+	return func(tx *Tx) {
+		tx.Note = note
+	}
+}
+
+// Close は Cache が使用中にしている全てのトランザクションの使用を終了します.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for _, tx := range c.Trans {
+		if rerr := tx.Release(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
 
-	// 現在時刻からトランザクション名を作成する
-	name := strconv.FormatInt(time.Now().UnixMilli(), 16)
-	newtx, err := newTx(c.dir, name)
+// 新しいトランザクションを作成します.
+//
+// 作成したトランザクションは使用中として扱われ Release を呼び出すまで破棄されません.
+func (c *Cache) NewTransaction(opts ...TxOption) (*Tx, error) {
+	var newtx *Tx
+	err := c.update(func() error {
+		var err error
+		if newtx, err = c.newTransaction(opts...); err != nil {
+			return err
+		}
+		return c.discardNew(newtx)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return newtx, nil
+}
 
-	c.Trans = append(c.Trans, zero)
-	copy(c.Trans[1:], c.Trans[:len(c.Trans)])
-	c.Trans[0] = *newtx
+// newTransaction は新しいトランザクションを作成し先頭に追加します.
+func (c *Cache) newTransaction(opts ...TxOption) (*Tx, error) {
+	newtx := newTx(c, "")
+	for _, opt := range opts {
+		opt(newtx)
+	}
 
-	if err = c.discard(); err != nil {
-		return nil, err
+	if newtx.Name == "" {
+		// 現在時刻からトランザクション名を作成する
+		// 他のプロセスと同時に作成した場合も重複しないようにする
+		ms := time.Now().UnixMilli()
+		newtx.Name = strconv.FormatInt(ms, 16)
+		for c.findTx(newtx.Name) != nil {
+			ms++
+			newtx.Name = strconv.FormatInt(ms, 16)
+		}
+	} else if !validTxName(newtx.Name) {
+		return nil, fmt.Errorf("new transaction %q: %w", newtx.Name, errInvalidTx)
+	} else if c.findTx(newtx.Name) != nil {
+		return nil, fmt.Errorf("new transaction %q: %w", newtx.Name, errTxExists)
 	}
-	if err = c.saveCtlFile(); err != nil {
+	if err := newtx.acquire(); err != nil {
 		return nil, err
 	}
+
+	c.Trans = append(c.Trans, nil)
+	copy(c.Trans[1:], c.Trans[:len(c.Trans)])
+	c.Trans[0] = newtx
 	return newtx, nil
 }
 
 // GetLastTransaction は最後に作成されたトランザクションを返します.
-func (c *Cache) GetLastTransaction() (*Tx, error) {
-	if len(c.Trans) == 0 {
-		c.NewTransaction()
+//
+// filters を指定すると条件を満たすトランザクションのうち最後に作成されたものを返します.
+// 例えば Committed を指定すると最後にコミットされたトランザクションを返します.
+//
+// filters を指定せずトランザクションが 1 つもない場合は新しいトランザクションを作成します.
+func (c *Cache) GetLastTransaction(filters ...Filter) (*Tx, error) {
+	var tx *Tx
+	err := c.update(func() error {
+		var err error
+		if len(filters) > 0 {
+			found := c.filter(filters...)
+			if len(found) == 0 {
+				return fmt.Errorf("get last transaction: %w", errNoSuchTx)
+			}
+			tx = found[0]
+			return tx.acquire()
+		}
+		if len(c.Trans) == 0 {
+			if tx, err = c.newTransaction(); err != nil {
+				return err
+			}
+			return c.discardNew(tx)
+		}
+		tx = c.Trans[0]
+		return tx.acquire()
+	})
+	if err != nil {
+		return nil, err
 	}
-	return &c.Trans[0], nil
+	return tx, nil
 }
 
 // GetTransaction は指定されたトランザクションを返します.
 func (c *Cache) GetTransaction(name string) (*Tx, error) {
+	var tx *Tx
+	err := c.view(func() error {
+		if tx = c.findTx(name); tx == nil {
+			return fmt.Errorf("get transaction %q: %w", name, errNoSuchTx)
+		}
+		return tx.acquire()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Filter はトランザクションを絞り込む条件です.
+type Filter func(tx *Tx) bool
+
+// HasLabel はラベル label が付いたトランザクションに絞り込みます.
+func HasLabel(label string) Filter {
+	return func(tx *Tx) bool {
+		for _, l := range tx.Labels {
+			if l == label {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Committed はコミットされたトランザクションに絞り込みます.
+func Committed(tx *Tx) bool {
+	return tx.Status == StatusCommitted
+}
+
+// Pinned は固定されたトランザクションに絞り込みます.
+func Pinned(tx *Tx) bool {
+	return tx.Pinned
+}
+
+// Find は全ての filters を満たすトランザクションを新しい順に返します.
+func (c *Cache) Find(filters ...Filter) ([]*Tx, error) {
+	var found []*Tx
+	err := c.view(func() error {
+		found = c.filter(filters...)
+		return nil
+	})
+	return found, err
+}
+
+// filter は全ての filters を満たすトランザクションを新しい順に返します.
+func (c *Cache) filter(filters ...Filter) []*Tx {
+	var found []*Tx
+next:
+	for _, tx := range c.Trans {
+		for _, fn := range filters {
+			if !fn(tx) {
+				continue next
+			}
+		}
+		found = append(found, tx)
+	}
+	return found
+}
+
+// snapshot は管理ファイルを読み込まずに 読み込み済みのトランザクションから
+// 全ての filters を満たすものを新しい順に返します.
+func (c *Cache) snapshot(filters ...Filter) []*Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filter(filters...)
+}
+
+// Pin はトランザクションを固定し 破棄されないようにします.
+func (c *Cache) Pin(name string) error {
+	return c.setPinned("pin", name, true)
+}
+
+// Unpin はトランザクションの固定を解除します.
+func (c *Cache) Unpin(name string) error {
+	return c.setPinned("unpin", name, false)
+}
+
+// setPinned はトランザクションの固定状態を変更します.
+func (c *Cache) setPinned(op, name string, pinned bool) error {
+	return c.update(func() error {
+		tx := c.findTx(name)
+		if tx == nil {
+			return fmt.Errorf("%s transaction %q: %w", op, name, errNoSuchTx)
+		}
+		tx.Pinned = pinned
+		return c.discardAll()
+	})
+}
+
+// findTx は名前が name のトランザクションを返します.
+func (c *Cache) findTx(name string) *Tx {
 	for i, l := 0, len(c.Trans); i < l; i++ {
 		if c.Trans[i].Name == name {
-			return &c.Trans[i], nil
+			return c.Trans[i]
 		}
 	}
-	return nil, fmt.Errorf("get transaction %q: %w", name, errNoSuchTx)
+	return nil
 }
 
-// discard はキャッシュ作成時に指定したトランザクション数を超えた
-// 古いトランザクションを削除します.
-func (c *Cache) discard() error {
-	if len(c.Trans) <= c.numTx {
+// discardAll は保持ルールに従って古いトランザクションを破棄します.
+func (c *Cache) discardAll() error {
+	_, err := c.discard()
+	return err
+}
+
+// discardNew は作成したばかりの newtx を残して古いトランザクションを破棄します.
+//
+// 破棄に失敗した場合は newtx の作成を取り消し 途中まで破棄した結果だけを管理ファイルに保存します.
+func (c *Cache) discardNew(newtx *Tx) error {
+	err := c.discardAll()
+	if err == nil {
 		return nil
 	}
-	for i, l := c.numTx, len(c.Trans); i < l; i++ {
-		if err := os.RemoveAll(c.Trans[i].dir); err != nil {
-			return err
+	c.Trans = removeTx(c.Trans, newtx)
+	newtx.Release()
+	if serr := c.saveCtlFile(); serr != nil {
+		return errors.Join(err, serr)
+	}
+	return err
+}
+
+// deleteTx はトランザクションが使用中でなければ削除します.
+func (c *Cache) deleteTx(tx *Tx) (deleted bool, err error) {
+	if tx.unlock != nil {
+		return false, nil
+	}
+	if l, ok := c.st.(Locker); ok {
+		unlock, ok, err := l.TryLockTx(tx.Name)
+		if err != nil || !ok {
+			return false, err
 		}
+		defer unlock()
 	}
-	c.Trans = c.Trans[:c.numTx]
-	return nil
+	if err = c.st.DeleteTx(tx.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lock は管理ファイルのロックを取得します.
+func (c *Cache) lock() (unlock func(), err error) {
+	c.mu.Lock()
+	l, ok := c.st.(Locker)
+	if !ok || c.ro {
+		return c.mu.Unlock, nil
+	}
+	unlockCtl, err := l.Lock()
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockCtl()
+		c.mu.Unlock()
+	}, nil
+}
+
+// view は管理ファイルをロックして最新の状態を読み込み fn を実行します.
+func (c *Cache) view(fn func() error) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = c.loadCtlFile(); err != nil && !isNotExist(err) {
+		return err
+	}
+	return fn()
+}
+
+// update は管理ファイルをロックして最新の状態を読み込み
+// fn を実行した後に管理ファイルを保存します.
+func (c *Cache) update(fn func() error) error {
+	if c.ro {
+		return errReadOnly
+	}
+	return c.view(func() error {
+		if err := fn(); err != nil {
+			return err
+		}
+		return c.saveCtlFile()
+	})
 }
 
 // loadCtlFile は管理ファイルを読み込みます.
+//
+// 読み込み済みのトランザクションは同じ *Tx を使い続けるよう内容を更新します.
 func (c *Cache) loadCtlFile() error {
-	if err := jsonb.Load(path.Join(c.dir, ctlname), c); err != nil {
+	var ctl struct {
+		Trans []*Tx `json:"transactions"`
+	}
+
+	b, err := c.st.GetMeta()
+	if err != nil {
 		return err
 	}
-	for i, l := 0, len(c.Trans); i < l; i++ {
-		c.Trans[i].dir = path.Join(c.dir, c.Trans[i].Name)
+	if err = json.Unmarshal(b, &ctl); err != nil {
+		return err
+	}
+	for i, tx := range ctl.Trans {
+		if old := c.findTx(tx.Name); old != nil {
+			old.refresh(tx)
+			ctl.Trans[i] = old
+		} else {
+			tx.txState = &txState{c: c, st: c.st}
+		}
+	}
+	c.Trans = ctl.Trans
+	for _, tx := range c.Trans {
+		var base *Tx
+		if tx.Base != "" {
+			base = c.findTx(tx.Base)
+		}
+		if tx.base != base {
+			tx.base = base
+		}
 	}
 	return nil
 }
 
+// refresh は管理ファイルから読み込んだ src の内容で tx を更新します.
+//
+// 使用中の *Tx は他の goroutine がロックせずに Name や Sealed を参照するため
+// 構造体ごと上書きせず 他のプロセスが変更した項目だけを書き換えます.
+func (tx *Tx) refresh(src *Tx) {
+	if tx.CreateAt != src.CreateAt {
+		tx.CreateAt = src.CreateAt
+	}
+	if !reflect.DeepEqual(tx.Labels, src.Labels) {
+		tx.Labels = src.Labels
+	}
+	if tx.Note != src.Note {
+		tx.Note = src.Note
+	}
+	if tx.Pinned != src.Pinned {
+		tx.Pinned = src.Pinned
+	}
+	// Status と Sealed は保存中の goroutine がロックせずに参照するため smu で保護する
+	tx.smu.Lock()
+	if tx.Status != src.Status {
+		tx.Status = src.Status
+	}
+	if tx.Sealed != src.Sealed {
+		tx.Sealed = src.Sealed
+	}
+	tx.smu.Unlock()
+	if tx.CloseAt != src.CloseAt {
+		tx.CloseAt = src.CloseAt
+	}
+	if !reflect.DeepEqual(tx.Stats, src.Stats) {
+		tx.Stats = src.Stats
+	}
+	if tx.Base != src.Base {
+		tx.Base = src.Base
+	}
+}
+
 // saveCtlFile は管理ファイルを保存します.
 func (c *Cache) saveCtlFile() error {
-	return jsonb.Save(path.Join(c.dir, ctlname), c)
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.st.PutMeta(b)
 }
 
 // Tx はトランザクションを表します.
 type Tx struct {
-	Name     string `json:"name"`
-	CreateAt string `json:"create_at"`
-	dir      string
+	Name     string   `json:"name"`
+	CreateAt string   `json:"create_at"`
+	Labels   []string `json:"labels,omitempty"`
+	Note     string   `json:"note,omitempty"`
+	Pinned   bool     `json:"pinned,omitempty"`   // 固定されていて破棄されない
+	Status   string   `json:"status,omitempty"`   // StatusOpen, StatusCommitted, StatusAborted
+	CloseAt  string   `json:"close_at,omitempty"` // コミットまたは中断した日時
+	Stats    *Stats   `json:"stats,omitempty"`    // コミット時の統計情報
+	Sealed   bool     `json:"sealed,omitempty"`   // 読み取り専用で保存できない
+	Base     string   `json:"base,omitempty"`     // フォーク元のトランザクション名
+	*txState
+}
+
+// txState はプロセス内で保持するトランザクションの状態を表します.
+type txState struct {
+	c        *Cache
+	st       Storage
+	base     *Tx          // フォーク元のトランザクション
+	unlock   func() error // 使用中ロックの解放
+	mu       sync.Mutex   // manifest の競合を制御する Mutex
+	manifest *manifest    // 読み込み済みのマニフェスト
+	smu      sync.RWMutex // Status と Sealed の競合を制御する RWMutex
 }
 
 // newTx は新しい Tx を作成します.
-func newTx(dir, name string) (*Tx, error) {
-	dir = path.Join(dir, name)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, err
-	}
+func newTx(c *Cache, name string) *Tx {
 	return &Tx{
 		Name:     name,
-		CreateAt: time.Now().Format("2006-01-02 15:04:05.000"),
-		dir:      dir,
-	}, nil
+		CreateAt: time.Now().Format(createAtLayout),
+		Status:   StatusOpen,
+		txState:  &txState{c: c, st: c.st},
+	}
+}
+
+// Created はトランザクションの作成日時を返します.
+func (tx *Tx) Created() time.Time {
+	t, err := time.ParseInLocation(createAtLayout, tx.CreateAt, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Size はトランザクションに含まれるエントリの合計サイズを返します.
+func (tx *Tx) Size() (int64, error) {
+	infos, err := tx.st.List(tx.Name)
+	if err != nil && !isNotExist(err) {
+		return 0, err
+	}
+	var size int64
+	for _, info := range infos {
+		size += info.Size
+	}
+	return size, nil
+}
+
+// validTxName はトランザクション名として使用できるかを返します.
+func validTxName(name string) bool {
+	return name != "" && name[0] != '.' && !strings.ContainsAny(name, `/\:`)
+}
+
+// acquire はトランザクションを使用中にします.
+func (tx *Tx) acquire() error {
+	if tx.unlock != nil {
+		return nil
+	}
+	l, ok := tx.st.(Locker)
+	if !ok || tx.c.ro {
+		tx.unlock = func() error { return nil }
+		return nil
+	}
+	unlock, err := l.LockTx(tx.Name)
+	if err != nil {
+		return err
+	}
+	tx.unlock = unlock
+	return nil
+}
+
+// Release はトランザクションの使用を終了します.
+//
+// 使用を終了したトランザクションは最大世代数を超えると破棄されるようになります.
+func (tx *Tx) Release() error {
+	if tx.unlock == nil {
+		return nil
+	}
+	unlock := tx.unlock
+	tx.unlock = nil
+	return unlock()
 }
 
 // NewFile は http.Request に対応した File を作成します.
@@ -147,109 +593,246 @@ func (tx *Tx) NewFile(req *http.Request) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
-	pathname := path.Join(tx.dir, creq.ident())
-	return &File{creq, pathname}, nil
+	return &File{creq, tx, creq.ident()}, nil
+}
+
+// Open は name のキャッシュファイルから http.Response を返します.
+//
+// name には Entry.Name を指定します.
+func (tx *Tx) Open(name string) (*http.Response, error) {
+	f := &File{tx: tx, name: name}
+	return f.Load()
 }
 
 // File は http.Request に対応したキャッシュファイルを表します.
 type File struct { // TODO: rename 名称がしっくりこない
-	creq     *cReq
-	pathname string
+	creq *cReq
+	tx   *Tx
+	name string
+}
+
+// Name はキャッシュファイルの名前を返します.
+func (f *File) Name() string {
+	return f.name
 }
 
 // IsExists はキャッシュファイルがあるかを返します.
+//
+// フォークしたトランザクションはフォーク元のキャッシュファイルも対象にします.
 func (f *File) IsExists() bool {
-	_, err := os.Stat(f.pathname)
-	return err == nil
+	return f.source() != nil
 }
 
-// Load はキャッシュファイルから http.Response を返します.
-func (f *File) Load() (*http.Response, error) {
-	var creq cReq
-	var cres cRes
-
-	r, err := os.Open(f.pathname)
-	if err != nil {
-		return nil, err
+// Entry はキャッシュファイルのエントリを返します.
+//
+// フォークしたトランザクションはフォーク元のエントリも対象にします.
+// キャッシュファイルが無い場合は false を返します.
+func (f *File) Entry() (Entry, bool, error) {
+	src := f.source()
+	if src == nil {
+		return Entry{}, false, nil
 	}
+	return src.Entry(f.name)
+}
 
-	err = func(r *os.File) error {
-		dec := json.NewDecoder(r)
-		if err = dec.Decode(&creq); err != nil {
-			return err
-		}
-		if err = dec.Decode(&cres); err != nil {
-			return err
+// source はキャッシュファイルを保持しているトランザクションを返します.
+//
+// フォークしたトランザクションに無い場合はフォーク元を遡って探し
+// どこにも無ければ nil を返します.
+func (f *File) source() *Tx {
+	for tx := f.tx; tx != nil; tx = tx.base {
+		if _, err := tx.st.Stat(tx.Name, f.name); err == nil {
+			return tx
 		}
-		_, err = r.Seek(dec.InputOffset()+1, 0)
-		return err
-	}(r)
+	}
+	return nil
+}
+
+// Load はキャッシュファイルから http.Response を返します.
+//
+// リダイレクトされた場合 resp.Request は最後のリクエストになり
+// http.Client と同様に Request.Response を辿ってリダイレクトの経過を参照できます.
+// 所要時間が記録されている場合は ResponseTiming で参照できます.
+//
+// ネットワークエラーを記録したキャッシュファイルは記録した *NetError を返します.
+func (f *File) Load() (*http.Response, error) {
+	src := f.source()
+	if src == nil {
+		return nil, notExist("load", f.tx.Name, f.name)
+	}
+	creq, cres, body, err := src.load(f.name)
 	if err != nil {
-		r.Close()
 		return nil, err
 	}
+	if cres.Error != nil {
+		body.Close()
+		return nil, cres.Error
+	}
 
 	resp := cres.newResponse()
-	u, _ := url.Parse(creq.Url)
-	resp.Request = &http.Request{
-		Method: creq.Method,
-		URL:    u,
+	resp.Request = cres.newRequest(creq)
+	resp.Body = body
+	// ボディを読み終えるまでの所要時間はマニフェストにだけ記録されている
+	timing := cres.Timing
+	if ent, ok, err := src.Entry(f.name); err == nil && ok && ent.Timing != nil {
+		timing = ent.Timing
+	}
+	if timing != nil {
+		resp.Request = withTiming(resp.Request, timing)
 	}
-	resp.Body = r
 
 	return resp, nil
 }
 
 // Store はキャッシュファイルに http.Response の内容を保存します.
+//
+// 封印されたトランザクションには保存できず ErrSealed を返します.
 func (f *File) Store(resp *http.Response) error {
-	cres := newCres(resp)
+	return f.store(resp, time.Now())
+}
+
+// store はキャッシュファイルに http.Response の内容を保存し
+// 取得日時を fetchAt としてマニフェストに記録します.
+func (f *File) store(resp *http.Response, fetchAt time.Time) error {
+	return f.storeCres(newCres(resp), resp.Body, fetchAt, nil)
+}
+
+// StoreError はキャッシュファイルにネットワークエラーを記録します.
+//
+// 記録したキャッシュファイルを Load すると ne を返します.
+func (f *File) StoreError(ne *NetError) error {
+	return f.storeCres(&cRes{ContentLength: -1, Error: ne}, http.NoBody, time.Now(), nil)
+}
+
+// storeCres はキャッシュファイルにレスポンス情報とボディを保存します.
+//
+// timing は nil でなければレスポンス情報に書き込む時点の内容をキャッシュファイルに記録し
+// ボディを読み終えた時点の内容をマニフェストに記録します.
+// ボディはそのまま保存先に流し込むため Total はマニフェストにだけ記録されます.
+func (f *File) storeCres(cres *cRes, r io.Reader, fetchAt time.Time, timing *Timing) error {
+	if f.tx.IsSealed() {
+		return fmt.Errorf("store %q in transaction %q: %w", f.creq.Url, f.tx.Name, ErrSealed)
+	}
+	if f.tx.c.ro {
+		return fmt.Errorf("store %q in transaction %q: %w", f.creq.Url, f.tx.Name, errReadOnly)
+	}
+
+	if timing != nil {
+		t := *timing
+		cres.Timing = &t
+	}
 
-	w, err := os.Create(f.pathname)
+	// 識別子は伏せる前のリクエストから計算済みのため 伏せても名前は変わらない
+	rd := f.tx.c.redaction()
+	creq := rd.request(f.creq)
+	cres, r, err := rd.response(cres, r)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
 
-	enc := json.NewEncoder(w)
-	if err = enc.Encode(f.creq); err != nil {
+	head := &bytes.Buffer{}
+	enc := json.NewEncoder(head)
+	if err = enc.Encode(creq); err != nil {
 		return err
 	}
 	if err = enc.Encode(cres); err != nil {
 		return err
 	}
-	_, err = io.Copy(w, resp.Body)
-	return err
+	dw := newDigestWriter()
+	body := io.TeeReader(r, dw)
+	if err = f.tx.st.Put(f.tx.Name, f.name, io.MultiReader(head, body)); err != nil {
+		return err
+	}
+	ent := newEntry(f.name, creq, cres, dw, fetchAt)
+	if timing != nil {
+		t := *timing
+		ent.Timing = &t
+	}
+	return f.tx.putEntry(ent)
+}
+
+// load はキャッシュファイル name を読み込み リクエスト情報とレスポンス情報
+// 続くボディを読み出す io.ReadCloser を返します.
+func (tx *Tx) load(name string) (*cReq, *cRes, io.ReadCloser, error) {
+	var creq cReq
+	var cres cRes
+
+	r, err := tx.st.Get(tx.Name, name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	body, err := decodeEntry(r, &creq, &cres)
+	if err != nil {
+		r.Close()
+		return nil, nil, nil, err
+	}
+	return &creq, &cres, body, nil
+}
+
+// decodeEntry はキャッシュファイルの先頭からリクエスト情報とレスポンス情報を読み込み
+// 続くボディを読み出す io.ReadCloser を返します.
+func decodeEntry(r io.ReadCloser, creq *cReq, cres *cRes) (io.ReadCloser, error) {
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(creq); err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(cres); err != nil {
+		return nil, err
+	}
+
+	// Encode が出力した改行を読み飛ばす
+	body := io.MultiReader(dec.Buffered(), r)
+	if _, err := io.ReadFull(body, make([]byte, 1)); err != nil {
+		return nil, err
+	}
+	return &readCloser{body, r}, nil
+}
+
+// readCloser は io.Reader と io.Closer を組み合わせた io.ReadCloser です.
+type readCloser struct {
+	io.Reader
+	io.Closer
 }
 
 // cReq はキャッシュファイルに格納するリクエスト情報を表します.
 type cReq struct {
-	Method  string
-	Url     string
-	Payload []byte
+	Method   string
+	Url      string
+	Payload  []byte
+	Header   http.Header `json:",omitempty"` // リクエストヘッダ
+	Body     []byte      `json:",omitempty"` // Payload に収まらない場合のボディ全体
+	Redacted bool        `json:",omitempty"` // ボディの一部を伏せたため識別子が一致しない
 }
 
+// payloadSize は識別子の計算に使用するペイロードの最大バイト数です.
+const payloadSize = 256
+
 // newCreq は http.Request から新しい cReq を作成します.
 func newCreq(req *http.Request) (*cReq, error) {
-	payload, err := getPayload(req.GetBody)
+	body, err := getBody(req.GetBody)
 	if err != nil {
 		return nil, err
 	}
 
-	return &cReq{
-		req.Method,
-		req.URL.String(),
-		payload,
-	}, nil
+	creq := &cReq{
+		Method:  req.Method,
+		Url:     req.URL.String(),
+		Payload: body,
+		Header:  req.Header.Clone(),
+	}
+	if len(body) > payloadSize {
+		creq.Payload = body[:payloadSize]
+		creq.Body = body
+	}
+	return creq, nil
 }
 
-// getPayload は http.Request からペイロードを取得します.
+// getBody は http.Request からボディを取得します.
 //
-// ペイロードは Body からではなく GetBody から最大 256 bytes までを対象に取得します.
+// ボディは Body からではなく GetBody から取得します.
 // POST PUT や PATCH などで JSON や Form 形式を渡す場合 http パッケージは GetBodyを準備しており
 // 再利用可能な io.ReadCloser を返してくれるためです.
-func getPayload(getBody func() (io.ReadCloser, error)) ([]byte, error) {
-	const maxbytes = 256
-
+func getBody(getBody func() (io.ReadCloser, error)) ([]byte, error) {
 	if getBody == nil {
 		return nil, nil
 	}
@@ -258,16 +841,23 @@ func getPayload(getBody func() (io.ReadCloser, error)) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 	w := &bytes.Buffer{}
-	_, err = io.CopyN(w, r, maxbytes)
-	if err != nil && err != io.EOF {
+	if _, err = w.ReadFrom(r); err != nil {
 		return nil, err
 	}
-	r.Close()
 
 	return w.Bytes(), nil
 }
 
+// body はリクエストのボディ全体を返します.
+func (cq *cReq) body() []byte {
+	if cq.Body != nil {
+		return cq.Body
+	}
+	return cq.Payload
+}
+
 // ident は cReq の識別子を計算します.
 //
 // 識別子は Method, Url, Payload を元に MD5 チェックサムで計算されます.
@@ -293,11 +883,27 @@ type cRes struct {
 	ContentLength    int64
 	TransferEncoding []string
 	Uncompressed     bool
+	Error            *NetError `json:",omitempty"` // 記録したネットワークエラー
+	Method           string    `json:",omitempty"` // リダイレクトされた場合の最後のリクエストメソッド
+	Url              string    `json:",omitempty"` // リダイレクトされた場合の最後のリクエスト URL
+	Redirects        []cHop    `json:",omitempty"` // リダイレクトの経過
+	Timing           *Timing   `json:",omitempty"` // 所要時間と接続の情報 (Total を除く)
+}
+
+// cHop はリダイレクトの経過の 1 つを表します.
+type cHop struct {
+	Method     string
+	Url        string
+	Status     string
+	StatusCode int
+	Header     http.Header
 }
 
 // newCres は http.Response から新しい cRes を作成します.
+//
+// リダイレクトされた場合は最後のリクエストと経過も記録します.
 func newCres(resp *http.Response) *cRes {
-	return &cRes{
+	cres := &cRes{
 		Status:           resp.Status,
 		StatusCode:       resp.StatusCode,
 		Proto:            resp.Proto,
@@ -308,6 +914,66 @@ func newCres(resp *http.Response) *cRes {
 		TransferEncoding: resp.TransferEncoding,
 		Uncompressed:     resp.Uncompressed,
 	}
+	if req := resp.Request; req != nil && req.Response != nil {
+		cres.Method = req.Method
+		cres.Url = req.URL.String()
+		// 最初のリクエストから順に並べる
+		for r := req; r.Response != nil && r.Response.Request != nil; r = r.Response.Request {
+			hop := r.Response
+			cres.Redirects = append([]cHop{{
+				Method:     hop.Request.Method,
+				Url:        hop.Request.URL.String(),
+				Status:     hop.Status,
+				StatusCode: hop.StatusCode,
+				Header:     hop.Header,
+			}}, cres.Redirects...)
+		}
+	}
+	return cres
+}
+
+// newRequest は記録したリクエストをヘッダやボディと共に返します.
+//
+// リダイレクトされた場合は最後のリクエストを返し
+// Response にリダイレクトの経過を復元します.
+func (cs *cRes) newRequest(creq *cReq) *http.Request {
+	u, _ := url.Parse(creq.Url)
+	first := &http.Request{Method: creq.Method, URL: u, Header: creq.Header}
+	if body := creq.body(); body != nil {
+		first.ContentLength = int64(len(body))
+		first.Body = io.NopCloser(bytes.NewReader(body))
+		first.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	if first.Header == nil {
+		first.Header = make(http.Header)
+	}
+	if cs.Url == "" {
+		return first
+	}
+
+	// 最後のリクエストのヘッダは http.Client と同様に最初のリクエストを引き継いだものとする
+	u, _ = url.Parse(cs.Url)
+	req := &http.Request{Method: cs.Method, URL: u, Header: first.Header.Clone()}
+	last := req
+	for i := len(cs.Redirects) - 1; i >= 0; i-- {
+		hop := cs.Redirects[i]
+		hopreq := first
+		if i > 0 {
+			u, _ := url.Parse(hop.Url)
+			hopreq = &http.Request{Method: hop.Method, URL: u, Header: first.Header.Clone()}
+		}
+		last.Response = &http.Response{
+			Status:     hop.Status,
+			StatusCode: hop.StatusCode,
+			Header:     hop.Header,
+			Body:       http.NoBody,
+			Request:    hopreq,
+		}
+		last = hopreq
+	}
+	return req
 }
 
 // newResponse は cRes から http.Response を返します.
@@ -1,7 +1,10 @@
 package cache
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -57,3 +60,108 @@ func TestCache(t *testing.T) {
 
 	t.Logf("X-Crawl-Cache: %s", resp.Header.Get("X-Crawl-Cache"))
 }
+
+func TestMemory(t *testing.T) {
+	c := NewMemory(2)
+	var names []string
+	for i := 0; i < 3; i++ {
+		tx, err := c.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, tx.Name)
+		if err = tx.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.GetTransaction(names[0]); err == nil {
+		t.Errorf("%s = %v, want %v", "discarded transaction", nil, errNoSuchTx)
+	}
+	tx, err := c.GetLastTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Name != names[2] {
+		t.Errorf("%s = %q, want %q", "last transaction", tx.Name, names[2])
+	}
+}
+
+func TestPinAndLabels(t *testing.T) {
+	c := NewMemory(1)
+	tx, err := c.NewTransaction(WithName("broken"), WithLabels("parser", "weekly"), WithNote("parser broke"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Release()
+	if _, err = c.NewTransaction(WithName("broken")); !errors.Is(err, errTxExists) {
+		t.Errorf("%s = %v, want %v", "duplicate name", err, errTxExists)
+	}
+	if _, err = c.NewTransaction(WithName("../x")); !errors.Is(err, errInvalidTx) {
+		t.Errorf("%s = %v, want %v", "invalid name", err, errInvalidTx)
+	}
+	if err = c.Pin("broken"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		tx, err := c.NewTransaction(WithLabels("weekly"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Release()
+	}
+
+	found, err := c.Find(HasLabel("parser"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "broken" || found[0].Note != "parser broke" {
+		t.Errorf("%s = %v, want %v", "find parser", found, "[broken]")
+	}
+	if found, _ = c.Find(HasLabel("weekly")); len(found) != 2 {
+		t.Errorf("%s = %d, want %d", "find weekly", len(found), 2)
+	}
+
+	if err = c.Unpin("broken"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetTransaction("broken"); err == nil {
+		t.Errorf("%s = %v, want %v", "unpinned transaction", nil, errNoSuchTx)
+	}
+}
+
+func TestRequestFidelity(t *testing.T) {
+	c := NewMemory(3)
+	// Cookie は既定で伏せられるため 伏せるヘッダを明示して元の値が記録されることを確かめる
+	c.SetRedaction(&Redaction{Headers: []string{"Authorization"}})
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := strings.Repeat("a=1&", 100)
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/login", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "sid=1")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	cf := storeResponse(t, tx, req, http.StatusOK, "ok")
+
+	// 識別子は先頭 256 bytes のペイロードから計算すること
+	want := (&cReq{Method: http.MethodPost, Url: "https://example.com/login", Payload: []byte(payload[:256])}).ident()
+	if cf.Name() != want {
+		t.Errorf("%s = %q, want %q", "Name", cf.Name(), want)
+	}
+
+	resp, err := cf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	h := resp.Request.Header
+	if h.Get("Authorization") != DefaultPlaceholder || h.Get("Cookie") != "sid=1" {
+		t.Errorf("%s = %v, want redacted Authorization and Cookie", "Header", h)
+	}
+	b, _ := io.ReadAll(resp.Request.Body)
+	if string(b) != payload || resp.Request.ContentLength != int64(len(payload)) {
+		t.Errorf("%s = %d bytes, want %d bytes", "Body", len(b), len(payload))
+	}
+
+}
@@ -0,0 +1,355 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// cryptMagic は暗号化したデータの先頭に付ける識別子です.
+var cryptMagic = []byte("CBE1")
+
+// keyIDSize は暗号化に使用した鍵を識別する ID のバイト数です.
+const keyIDSize = 8
+
+var (
+	errNoKey        = errors.New("no encryption key")
+	errUnknownKey   = errors.New("unknown encryption key")
+	errNotEncrypted = errors.New("data is not encrypted")
+	errKeyFormat    = errors.New("invalid encryption key")
+)
+
+// EncryptedStorage はエントリと管理情報を AES-GCM で暗号化して保存する Storage です.
+//
+// 暗号化には先頭の鍵を使用し 復号には全ての鍵を使用するため
+// 新しい鍵を先頭に 古い鍵を後ろに指定すると鍵を切り替えることができます.
+// Rotate を呼び出すと古い鍵で暗号化したデータを先頭の鍵で暗号化し直します.
+//
+// 暗号化したデータには保存先の名前を関連データとして含めるため
+// 他のエントリと入れ替えられた場合は復号に失敗します.
+// Cache は復号したデータを扱うため ExportBundle が出力するバンドルは暗号化されません.
+type EncryptedStorage struct {
+	st   Storage
+	aead []cipher.AEAD
+	ids  [][]byte
+}
+
+// NewEncryptedStorage は st に暗号化して保存する新しい EncryptedStorage を作成します.
+//
+// 鍵は AES-128, AES-192, AES-256 に対応する 16, 24, 32 バイトのいずれかです.
+func NewEncryptedStorage(st Storage, keys ...[]byte) (*EncryptedStorage, error) {
+	if len(keys) == 0 {
+		return nil, errNoKey
+	}
+	s := &EncryptedStorage{st: st}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		s.aead = append(s.aead, aead)
+		s.ids = append(s.ids, sum[:keyIDSize])
+	}
+	return s, nil
+}
+
+// ParseKeys はカンマか改行で区切った 16 進数または Base64 の鍵を読み込みます.
+func ParseKeys(s string) ([][]byte, error) {
+	var keys [][]byte
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		key, err := hex.DecodeString(f)
+		if err != nil {
+			if key, err = base64.StdEncoding.DecodeString(f); err != nil {
+				return nil, fmt.Errorf("parse key: %w", errKeyFormat)
+			}
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("parse key: %d bytes: %w", len(key), errKeyFormat)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errNoKey
+	}
+	return keys, nil
+}
+
+// KeysFromEnv は環境変数 name から ParseKeys の形式で鍵を読み込みます.
+func KeysFromEnv(name string) ([][]byte, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("env %s: %w", name, errNoKey)
+	}
+	return ParseKeys(s)
+}
+
+// KeysFromFile は鍵ファイルから ParseKeys の形式で鍵を読み込みます.
+func KeysFromFile(path string) ([][]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeys(string(b))
+}
+
+// seal は先頭の鍵で b を暗号化します.
+func (s *EncryptedStorage) seal(b []byte, ad string) ([]byte, error) {
+	nonce := make([]byte, s.aead[0].NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(cryptMagic)+keyIDSize+len(nonce)+len(b)+s.aead[0].Overhead())
+	out = append(out, cryptMagic...)
+	out = append(out, s.ids[0]...)
+	out = append(out, nonce...)
+	return s.aead[0].Seal(out, nonce, b, []byte(ad)), nil
+}
+
+// open は暗号化した b を復号し 使用した鍵の番号を返します.
+func (s *EncryptedStorage) open(b []byte, ad string) ([]byte, int, error) {
+	if !bytes.HasPrefix(b, cryptMagic) || len(b) < len(cryptMagic)+keyIDSize {
+		return nil, -1, fmt.Errorf("open %s: %w", ad, errNotEncrypted)
+	}
+	b = b[len(cryptMagic):]
+	id, b := b[:keyIDSize], b[keyIDSize:]
+	for i, aead := range s.aead {
+		if !bytes.Equal(s.ids[i], id) {
+			continue
+		}
+		if len(b) < aead.NonceSize() {
+			return nil, -1, fmt.Errorf("open %s: %w", ad, errNotEncrypted)
+		}
+		plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(ad))
+		if err != nil {
+			return nil, -1, fmt.Errorf("open %s: %w", ad, err)
+		}
+		return plain, i, nil
+	}
+	return nil, -1, fmt.Errorf("open %s: %w", ad, errUnknownKey)
+}
+
+// GetMeta は管理情報を読み込んで復号します.
+func (s *EncryptedStorage) GetMeta() ([]byte, error) {
+	b, err := s.st.GetMeta()
+	if err != nil {
+		return nil, err
+	}
+	plain, _, err := s.open(b, ctlname)
+	return plain, err
+}
+
+// PutMeta は管理情報を暗号化して保存します.
+func (s *EncryptedStorage) PutMeta(b []byte) error {
+	b, err := s.seal(b, ctlname)
+	if err != nil {
+		return err
+	}
+	return s.st.PutMeta(b)
+}
+
+// Get はエントリを読み込んで復号します.
+func (s *EncryptedStorage) Get(tx, name string) (io.ReadCloser, error) {
+	plain, _, err := s.get(tx, name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
+
+// get はエントリを読み込んで復号し 使用した鍵の番号を返します.
+func (s *EncryptedStorage) get(tx, name string) ([]byte, int, error) {
+	r, err := s.st.Get(tx, name)
+	if err != nil {
+		return nil, -1, err
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, -1, err
+	}
+	return s.open(b, tx+"/"+name)
+}
+
+// Put はエントリを暗号化して保存します.
+func (s *EncryptedStorage) Put(tx, name string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if b, err = s.seal(b, tx+"/"+name); err != nil {
+		return err
+	}
+	return s.st.Put(tx, name, bytes.NewReader(b))
+}
+
+// Stat はエントリの情報を返します.
+func (s *EncryptedStorage) Stat(tx, name string) (Info, error) {
+	info, err := s.st.Stat(tx, name)
+	if err != nil {
+		return Info{}, err
+	}
+	return s.plainInfo(info), nil
+}
+
+// List はトランザクションに含まれるエントリの一覧を返します.
+func (s *EncryptedStorage) List(tx string) ([]Info, error) {
+	infos, err := s.st.List(tx)
+	for i := range infos {
+		infos[i] = s.plainInfo(infos[i])
+	}
+	return infos, err
+}
+
+// plainInfo は暗号化したエントリの Size を復号後のサイズにします.
+func (s *EncryptedStorage) plainInfo(info Info) Info {
+	overhead := int64(len(cryptMagic) + keyIDSize + s.aead[0].NonceSize() + s.aead[0].Overhead())
+	if info.Size -= overhead; info.Size < 0 {
+		info.Size = 0
+	}
+	return info
+}
+
+// Delete はエントリを削除します.
+func (s *EncryptedStorage) Delete(tx, name string) error {
+	return s.st.Delete(tx, name)
+}
+
+// ListTx は保存先に存在するトランザクション名の一覧を返します.
+func (s *EncryptedStorage) ListTx() ([]string, error) {
+	return s.st.ListTx()
+}
+
+// DeleteTx はトランザクションを全てのエントリと共に削除します.
+func (s *EncryptedStorage) DeleteTx(tx string) error {
+	return s.st.DeleteTx(tx)
+}
+
+// Lock は管理情報の排他ロックを取得します.
+//
+// 保存先が Locker を実装していない場合は何もしません.
+func (s *EncryptedStorage) Lock() (unlock func() error, err error) {
+	if l, ok := s.st.(Locker); ok {
+		return l.Lock()
+	}
+	return func() error { return nil }, nil
+}
+
+// LockTx はトランザクションの共有ロックを取得します.
+//
+// 保存先が Locker を実装していない場合は何もしません.
+func (s *EncryptedStorage) LockTx(tx string) (unlock func() error, err error) {
+	if l, ok := s.st.(Locker); ok {
+		return l.LockTx(tx)
+	}
+	return func() error { return nil }, nil
+}
+
+// TryLockTx はトランザクションの排他ロックを待たずに取得します.
+//
+// 保存先が Locker を実装していない場合は常に取得できます.
+func (s *EncryptedStorage) TryLockTx(tx string) (unlock func() error, ok bool, err error) {
+	if l, isLocker := s.st.(Locker); isLocker {
+		return l.TryLockTx(tx)
+	}
+	return func() error { return nil }, true, nil
+}
+
+// Rotate は先頭以外の鍵で暗号化した管理情報とエントリを先頭の鍵で暗号化し直し
+// 暗号化し直した数を返します.
+//
+// plain に true を指定すると暗号化されていないデータも暗号化するため
+// 既存のキャッシュディレクトリを暗号化する場合に利用できます.
+// Verify が隔離したエントリも暗号化し直すため 古い鍵を取り除いても読み込めます.
+// 他のプロセスがキャッシュを使用していない間に実行してください.
+func (s *EncryptedStorage) Rotate(plain bool) (int, error) {
+	unlock, err := s.Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	n := 0
+	b, err := s.st.GetMeta()
+	if err != nil && !isNotExist(err) {
+		return 0, err
+	} else if err == nil {
+		if meta, ok, err := s.reopen(b, ctlname, plain); err != nil {
+			return n, err
+		} else if ok {
+			if err = s.PutMeta(meta); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	txs, err := s.st.ListTx()
+	if err != nil && !isNotExist(err) {
+		return n, err
+	}
+	// ListTx が返さない隔離したエントリも対象にする
+	if !contains(txs, quarantineTx) {
+		txs = append(txs, quarantineTx)
+	}
+	for _, tx := range txs {
+		infos, err := s.st.List(tx)
+		if isNotExist(err) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		for _, info := range infos {
+			r, err := s.st.Get(tx, info.Name)
+			if err != nil {
+				return n, err
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return n, err
+			}
+			data, ok, err := s.reopen(b, tx+"/"+info.Name, plain)
+			if err != nil {
+				return n, err
+			} else if !ok {
+				continue
+			}
+			if err = s.Put(tx, info.Name, bytes.NewReader(data)); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// reopen は暗号化し直す必要があれば復号したデータと true を返します.
+func (s *EncryptedStorage) reopen(b []byte, ad string, plain bool) ([]byte, bool, error) {
+	data, i, err := s.open(b, ad)
+	if errors.Is(err, errNotEncrypted) && plain {
+		return b, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return data, i != 0, nil
+}
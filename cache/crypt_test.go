@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	st, err := NewEncryptedStorage(NewMemStorage(), k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, st)
+
+	// 暗号化したキャッシュは Cache から透過的に使用できること
+	raw := NewMemStorage()
+	if st, err = NewEncryptedStorage(raw, k1); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewWithStorage(st, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/secret", nil)
+	cf := storeResponse(t, tx, req, http.StatusOK, "personal data")
+	if got := loadBody(t, tx, req); got != "personal data" {
+		t.Errorf("%s = %q, want %q", "body", got, "personal data")
+	}
+	r, _ := raw.Get(tx.Name, cf.Name())
+	b, _ := io.ReadAll(r)
+	if bytes.Contains(b, []byte("personal data")) || bytes.Contains(b, []byte("example.com")) {
+		t.Errorf("%s = %q, want encrypted", "raw entry", b)
+	}
+	if b, _ = raw.GetMeta(); bytes.Contains(b, []byte(tx.Name)) {
+		t.Errorf("%s = %q, want encrypted", "raw meta", b)
+	}
+
+	// 入れ替えたエントリは復号できないこと
+	raw.Put(tx.Name, "swapped", bytes.NewReader(b))
+	if _, err = st.Get(tx.Name, "swapped"); err == nil {
+		t.Errorf("%s = %v, want error", "swapped", err)
+	}
+	raw.Delete(tx.Name, "swapped")
+
+	// 新しい鍵に切り替えて暗号化し直すこと
+	rotated, err := NewEncryptedStorage(raw, k2, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Put(tx.Name, "plain", strings.NewReader("plain"))
+	n, err := rotated.Rotate(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 { // 管理情報 マニフェスト エントリ 平文のエントリ
+		t.Errorf("%s = %d, want %d", "rotated", n, 4)
+	}
+	only2, _ := NewEncryptedStorage(raw, k2)
+	if _, err = only2.GetMeta(); err != nil {
+		t.Errorf("%s = %v, want nil", "GetMeta with new key", err)
+	}
+	if _, err = st.Get(tx.Name, cf.Name()); !errors.Is(err, errUnknownKey) {
+		t.Errorf("%s = %v, want %v", "Get with old key", err, errUnknownKey)
+	}
+	if r, err = only2.Get(tx.Name, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ = io.ReadAll(r); string(b) != "plain" {
+		t.Errorf("%s = %q, want %q", "plain", b, "plain")
+	}
+
+	// ListTx が返さない隔離したエントリも暗号化し直すこと
+	dir := NewDirStorage(t.TempDir())
+	if st, err = NewEncryptedStorage(dir, k1); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Put(quarantineTx, "x", strings.NewReader("quarantined")); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ = NewEncryptedStorage(dir, k2, k1)
+	if _, err = rotated.Rotate(false); err != nil {
+		t.Fatal(err)
+	}
+	only2, _ = NewEncryptedStorage(dir, k2)
+	if r, err = only2.Get(quarantineTx, "x"); err != nil {
+		t.Fatalf("%s = %v, want nil", "Get quarantined with new key", err)
+	}
+	if b, _ = io.ReadAll(r); string(b) != "quarantined" {
+		t.Errorf("%s = %q, want %q", "quarantined", b, "quarantined")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 24)
+	keys, err := ParseKeys(hex.EncodeToString(k1) + ",\n" + base64.StdEncoding.EncodeToString(k2) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[0], k1) || !bytes.Equal(keys[1], k2) {
+		t.Errorf("%s = %x, want %x, %x", "keys", keys, k1, k2)
+	}
+	for _, s := range []string{"", "zz", hex.EncodeToString([]byte("short"))} {
+		if _, err = ParseKeys(s); err == nil {
+			t.Errorf("%s(%q) = %v, want error", "ParseKeys", s, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// ChangeKind は変更の種類を表します.
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"    // 新しいトランザクションだけにある
+	Removed  ChangeKind = "removed"  // 古いトランザクションだけにある
+	Modified ChangeKind = "modified" // 両方にあり内容が異なる
+)
+
+// DefaultDiffHeaders は Diff が既定で比較するレスポンスヘッダです.
+var DefaultDiffHeaders = []string{"ETag", "Last-Modified"}
+
+// DiffOptions は Diff のオプションです.
+type DiffOptions struct {
+	Headers  []string // 比較するレスポンスヘッダ. nil の場合は DefaultDiffHeaders
+	TextDiff bool     // テキストのボディが異なる場合に unified 形式の差分を作成する
+	Context  int      // unified 形式の差分で前後に出力する行数. 0 以下の場合は 3
+}
+
+// Change はリクエスト毎の変更内容を表します.
+type Change struct {
+	Kind     ChangeKind
+	Method   string
+	URL      string
+	Old      *Entry         // 古いトランザクションのエントリ. Added の場合は nil
+	New      *Entry         // 新しいトランザクションのエントリ. Removed の場合は nil
+	Headers  []HeaderChange // 変更されたレスポンスヘッダ
+	TextDiff string         // ボディの unified 形式の差分
+}
+
+// StatusChanged はステータスコードが変更されたかを返します.
+func (ch *Change) StatusChanged() bool {
+	return ch.Old != nil && ch.New != nil && ch.Old.StatusCode != ch.New.StatusCode
+}
+
+// BodyChanged はボディが変更されたかを返します.
+func (ch *Change) BodyChanged() bool {
+	return ch.Old != nil && ch.New != nil && ch.Old.ContentHash != ch.New.ContentHash
+}
+
+// ErrorChanged は記録したネットワークエラーが変更されたかを返します.
+func (ch *Change) ErrorChanged() bool {
+	return ch.Old != nil && ch.New != nil && ch.Old.Error != ch.New.Error
+}
+
+// HeaderChange はレスポンスヘッダの変更を表します.
+type HeaderChange struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// Diff は古いトランザクション a から新しいトランザクション b への変更を返します.
+//
+// リクエストはメソッド, URL, ペイロードが同じものを対応付けます.
+// 変更のないリクエストは結果に含まれません.
+func Diff(a, b *Tx, opts *DiffOptions) ([]Change, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	headers := opts.Headers
+	if headers == nil {
+		headers = DefaultDiffHeaders
+	}
+	context := opts.Context
+	if context <= 0 {
+		context = 3
+	}
+
+	aents, err := a.AllEntries()
+	if err != nil {
+		return nil, err
+	}
+	bents, err := b.AllEntries()
+	if err != nil {
+		return nil, err
+	}
+	olds := make(map[string]*Entry, len(aents))
+	for i := range aents {
+		olds[aents[i].Name] = &aents[i]
+	}
+
+	var changes []Change
+	for i := range bents {
+		nent := &bents[i]
+		oent, ok := olds[nent.Name]
+		if !ok {
+			changes = append(changes, Change{Kind: Added, Method: nent.Method, URL: nent.URL, New: nent})
+			continue
+		}
+		delete(olds, nent.Name)
+
+		ch := Change{Kind: Modified, Method: nent.Method, URL: nent.URL, Old: oent, New: nent}
+		if err = compareEntry(&ch, a, b, headers, opts.TextDiff, context); err != nil {
+			return nil, err
+		}
+		if ch.StatusChanged() || ch.BodyChanged() || ch.ErrorChanged() || len(ch.Headers) > 0 {
+			changes = append(changes, ch)
+		}
+	}
+	for i := range aents {
+		if oent, ok := olds[aents[i].Name]; ok {
+			changes = append(changes, Change{Kind: Removed, Method: oent.Method, URL: oent.URL, Old: oent})
+		}
+	}
+	return changes, nil
+}
+
+// compareEntry はキャッシュファイルを読み込んでヘッダとボディを比較します.
+func compareEntry(ch *Change, a, b *Tx, headers []string, textDiff bool, context int) error {
+	if ch.Old.Error != "" || ch.New.Error != "" {
+		// ネットワークエラーにはヘッダもボディもない
+		return nil
+	}
+	oresp, err := a.Open(ch.Old.Name)
+	if err != nil {
+		return err
+	}
+	defer oresp.Body.Close()
+	nresp, err := b.Open(ch.New.Name)
+	if err != nil {
+		return err
+	}
+	defer nresp.Body.Close()
+
+	for _, name := range headers {
+		o, n := oresp.Header.Get(name), nresp.Header.Get(name)
+		if o != n {
+			ch.Headers = append(ch.Headers, HeaderChange{http.CanonicalHeaderKey(name), o, n})
+		}
+	}
+
+	if !textDiff || !ch.BodyChanged() || !IsText(oresp.Header) || !IsText(nresp.Header) {
+		return nil
+	}
+	otext, err := ReadText(oresp)
+	if err != nil {
+		return err
+	}
+	ntext, err := ReadText(nresp)
+	if err != nil {
+		return err
+	}
+	ch.TextDiff = unifiedDiff(a.Name+" "+ch.URL, b.Name+" "+ch.URL, otext, ntext, context)
+	return nil
+}
+
+// IsText は Content-Type がテキストを表しているかを返します.
+func IsText(h http.Header) bool {
+	mediatype, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediatype, "text/"),
+		strings.HasSuffix(mediatype, "json"),
+		strings.HasSuffix(mediatype, "xml"),
+		strings.HasSuffix(mediatype, "javascript"):
+		return true
+	}
+	return false
+}
+
+// ReadText はレスポンスのボディを読み込み Content-Type の charset に従って UTF-8 に変換します.
+//
+// charset が指定されていないか不明な場合は変換しません.
+func ReadText(resp *http.Response) (string, error) {
+	var r io.Reader = resp.Body
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if charset := params["charset"]; charset != "" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			r = enc.NewDecoder().Reader(r)
+		}
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", ""},
+		{"a\nb\nc\n", "a\nx\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"", "a\n", "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n"},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"0\n1\n2\n3\n4\n5\n6\n7\n8\n10\n",
+			"--- a\n+++ b\n@@ -1,1 +1,2 @@\n+0\n 1\n@@ -8,3 +9,2 @@\n 8\n-9\n 10\n",
+		},
+	}
+	for _, tt := range tests {
+		got := unifiedDiff("a", "b", tt.a, tt.b, 1)
+		if got != tt.want {
+			t.Errorf("unifiedDiff(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	// 編集を適用すると b になり 編集の数が最短であること
+	rnd := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		l := make([]string, rnd.Intn(12))
+		for i := range l {
+			l[i] = string(rune('a' + rnd.Intn(3)))
+		}
+		return l
+	}
+	for n := 0; n < 500; n++ {
+		a, b := lines(), lines()
+		var got []string
+		changes := 0
+		for _, e := range diffLines(a, b) {
+			switch e.op {
+			case opEqual:
+				if a[e.a] != b[e.b] {
+					t.Fatalf("diffLines(%q, %q): equal %d, %d", a, b, e.a, e.b)
+				}
+				got = append(got, a[e.a])
+			case opInsert:
+				got = append(got, b[e.b])
+				changes++
+			case opDelete:
+				changes++
+			}
+		}
+		if strings.Join(got, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%q, %q) = %q", a, b, got)
+		}
+		if want := len(a) + len(b) - 2*lcsLen(a, b); changes != want {
+			t.Fatalf("diffLines(%q, %q): %d changes, want %d", a, b, changes, want)
+		}
+	}
+
+	// 全て書き換えても使用するメモリは行数に比例すること
+	a, b := make([]string, 3000), make([]string, 3000)
+	for i := range a {
+		a[i], b[i] = fmt.Sprintf("a%d\n", i), fmt.Sprintf("b%d\n", i)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diffLines(a, b)
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 32<<20 {
+		t.Errorf("%s = %d, want <= %d", "alloc", alloc, 32<<20)
+	}
+}
+
+// lcsLen は a と b の最長共通部分列の長さを返します.
+func lcsLen(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] > dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestDiff(t *testing.T) {
+	c := NewMemory(3)
+	store := func(tx *Tx, url string, code int, etag, body string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		cf, err := tx.NewFile(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cf.Store(&http.Response{
+			StatusCode: code,
+			Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Etag": {etag}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store(a, "https://example.com/same", 200, `"1"`, "same\n")
+	store(a, "https://example.com/changed", 200, `"1"`, "x\ny\n")
+	store(a, "https://example.com/removed", 200, `"1"`, "")
+	b, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store(b, "https://example.com/same", 200, `"1"`, "same\n")
+	store(b, "https://example.com/changed", 404, `"2"`, "x\nz\n")
+	store(b, "https://example.com/added", 200, `"1"`, "")
+
+	changes, err := Diff(a, b, &DiffOptions{TextDiff: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ch := range changes {
+		got = append(got, string(ch.Kind)+" "+ch.URL)
+	}
+	want := []string{
+		"modified https://example.com/changed",
+		"added https://example.com/added",
+		"removed https://example.com/removed",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("%s = %v, want %v", "changes", got, want)
+	}
+
+	ch := changes[0]
+	if !ch.StatusChanged() || !ch.BodyChanged() {
+		t.Errorf("%s = %v, %v, want %v, %v", "status, body changed", ch.StatusChanged(), ch.BodyChanged(), true, true)
+	}
+	if len(ch.Headers) != 1 || ch.Headers[0] != (HeaderChange{"Etag", `"1"`, `"2"`}) {
+		t.Errorf("%s = %v", "headers", ch.Headers)
+	}
+	if !strings.Contains(ch.TextDiff, "-y\n+z\n") {
+		t.Errorf("%s = %q", "text diff", ch.TextDiff)
+	}
+}
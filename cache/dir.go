@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// DirStorage はディレクトリに保存する Storage です.
+//
+// 管理情報は dir/cache.json に エントリは dir/トランザクション名/エントリ名 に保存します.
+// DirStorage は Locker と Appender を実装していて ロックファイルを dir/.locks に作成します.
+type DirStorage struct {
+	dir string
+}
+
+// NewDirStorage は新しい DirStorage を作成します.
+func NewDirStorage(dir string) *DirStorage {
+	return &DirStorage{dir: dir}
+}
+
+// Dir はキャッシュディレクトリを返します.
+func (s *DirStorage) Dir() string {
+	return s.dir
+}
+
+// GetMeta は管理情報を読み込みます.
+func (s *DirStorage) GetMeta() ([]byte, error) {
+	return os.ReadFile(path.Join(s.dir, ctlname))
+}
+
+// PutMeta は管理情報を保存します.
+func (s *DirStorage) PutMeta(b []byte) error {
+	return writeFile(s.dir, ctlname, bytes.NewReader(b))
+}
+
+// Get はエントリを読み込みます.
+func (s *DirStorage) Get(tx, name string) (io.ReadCloser, error) {
+	return os.Open(path.Join(s.dir, tx, name))
+}
+
+// Put はエントリを保存します.
+//
+// 一時ファイルに書き込んでからリネームするため
+// 書き込み途中のエントリが他から参照されることはありません.
+func (s *DirStorage) Put(tx, name string, r io.Reader) error {
+	return writeFile(path.Join(s.dir, tx), name, r)
+}
+
+// Append はエントリの末尾に b を追記します.
+//
+// O_APPEND で 1 回の書き込みにするため 他のプロセスの追記と混ざりません.
+func (s *DirStorage) Append(tx, name string, b []byte) error {
+	dir := path.Join(s.dir, tx)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path.Join(dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Stat はエントリの情報を返します.
+func (s *DirStorage) Stat(tx, name string) (Info, error) {
+	fi, err := os.Stat(path.Join(s.dir, tx, name))
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{name, fi.Size(), fi.ModTime()}, nil
+}
+
+// List はトランザクションに含まれるエントリの一覧を返します.
+func (s *DirStorage) List(tx string) ([]Info, error) {
+	ents, err := os.ReadDir(path.Join(s.dir, tx))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(ents))
+	for _, ent := range ents {
+		if ent.IsDir() || strings.HasPrefix(ent.Name(), tmpPrefix) {
+			continue
+		}
+		fi, err := ent.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, Info{ent.Name(), fi.Size(), fi.ModTime()})
+	}
+	return infos, nil
+}
+
+// Delete はエントリを削除します.
+func (s *DirStorage) Delete(tx, name string) error {
+	return os.Remove(path.Join(s.dir, tx, name))
+}
+
+// ListTx は保存先に存在するトランザクション名の一覧を返します.
+func (s *DirStorage) ListTx() ([]string, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ent := range ents {
+		if ent.IsDir() && !strings.HasPrefix(ent.Name(), ".") {
+			names = append(names, ent.Name())
+		}
+	}
+	return names, nil
+}
+
+// DeleteTx はトランザクションを全てのエントリと共に削除します.
+func (s *DirStorage) DeleteTx(tx string) error {
+	if err := os.RemoveAll(path.Join(s.dir, tx)); err != nil {
+		return err
+	}
+	err := os.Remove(path.Join(s.dir, lockdir, tx))
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// lockdir はロックファイルを作成するディレクトリ名です.
+const lockdir = ".locks"
+
+// Lock は管理情報の排他ロックを取得します.
+func (s *DirStorage) Lock() (unlock func() error, err error) {
+	unlock, _, err = s.flock(ctlname, false, true)
+	return unlock, err
+}
+
+// LockTx はトランザクションの共有ロックを取得します.
+func (s *DirStorage) LockTx(tx string) (unlock func() error, err error) {
+	unlock, _, err = s.flock(tx, true, true)
+	return unlock, err
+}
+
+// TryLockTx はトランザクションの排他ロックを待たずに取得します.
+func (s *DirStorage) TryLockTx(tx string) (unlock func() error, ok bool, err error) {
+	return s.flock(tx, false, false)
+}
+
+// flock は dir/.locks/name のファイルロックを取得します.
+func (s *DirStorage) flock(name string, shared, wait bool) (func() error, bool, error) {
+	dir := path.Join(s.dir, lockdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, err
+	}
+	return flock(path.Join(dir, name), shared, wait)
+}
+
+// tmpPrefix は書き込み途中の一時ファイルに付ける接頭辞です.
+const tmpPrefix = ".tmp-"
+
+// writeFile は dir/name に r の内容をアトミックに書き込みます.
+func writeFile(dir, name string, r io.Reader) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	w, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if err = w.Chmod(0644); err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return err
+	}
+	if err = w.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return os.Rename(w.Name(), path.Join(dir, name))
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+)
+
+// Fork はトランザクション name をフォークした新しいトランザクションを作成します.
+//
+// フォークしたトランザクションはキャッシュファイルが無ければフォーク元から読み込み
+// 新しく取得した結果だけを自身に保存します (コピーオンライト).
+// 過去のトランザクションを変更せずに その上で新しいリクエストを試すことができます.
+//
+// フォーク元はフォークしたトランザクションが残っている間は破棄されません.
+func (c *Cache) Fork(name string, opts ...TxOption) (*Tx, error) {
+	var newtx *Tx
+	err := c.update(func() error {
+		base := c.findTx(name)
+		if base == nil {
+			return fmt.Errorf("fork transaction %q: %w", name, errNoSuchTx)
+		}
+		opts = append([]TxOption{func(tx *Tx) {
+			tx.Base = base.Name
+			tx.base = base
+		}}, opts...)
+
+		var err error
+		if newtx, err = c.newTransaction(opts...); err != nil {
+			return err
+		}
+		return c.discardNew(newtx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newtx, nil
+}
+
+// AllEntries はフォーク元を含めてトランザクションから参照できるエントリを返します.
+//
+// フォーク元と同じリクエストを記録している場合はフォークしたトランザクションのエントリを優先します.
+// フォークしていないトランザクションでは Entries と同じ結果を返します.
+func (tx *Tx) AllEntries() ([]Entry, error) {
+	var all []Entry
+	seen := make(map[string]bool)
+	for t := tx; t != nil; t = t.base {
+		ents, err := t.Entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range ents {
+			if !seen[ent.Name] {
+				seen[ent.Name] = true
+				all = append(all, ent)
+			}
+		}
+	}
+	return all, nil
+}
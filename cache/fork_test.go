@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// loadBody は req に対するキャッシュファイルのボディを返します.
+func loadBody(t *testing.T, tx *Tx, req *http.Request) string {
+	t.Helper()
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFork(t *testing.T) {
+	c := NewMemory(2)
+	base, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := http.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	b, _ := http.NewRequest(http.MethodGet, "https://example.com/b", nil)
+	storeResponse(t, base, a, http.StatusOK, "base a")
+	if err = base.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	base.Release()
+
+	fork, err := c.Fork(base.Name, WithLabels("repro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loadBody(t, fork, a); got != "base a" {
+		t.Errorf("%s = %q, want %q", "read through", got, "base a")
+	}
+
+	storeResponse(t, fork, a, http.StatusOK, "fork a")
+	storeResponse(t, fork, b, http.StatusOK, "fork b")
+	if got := loadBody(t, fork, a); got != "fork a" {
+		t.Errorf("%s = %q, want %q", "refreshed", got, "fork a")
+	}
+	if got := loadBody(t, base, a); got != "base a" {
+		t.Errorf("%s = %q, want %q", "base", got, "base a")
+	}
+	if cf, _ := base.NewFile(b); cf.IsExists() {
+		t.Errorf("%s = %v, want %v", "base has new entry", true, false)
+	}
+	if ents, err := fork.AllEntries(); err != nil || len(ents) != 2 {
+		t.Errorf("%s = %d, %v, want %d", "len(all entries)", len(ents), err, 2)
+	}
+
+	// フォークが残っている間はフォーク元も破棄されないこと
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Release()
+	if _, err = c.GetTransaction(base.Name); err != nil {
+		t.Errorf("%s = %v, want %v", "base", err, nil)
+	}
+}
+
+func TestForkDiscardFailure(t *testing.T) {
+	// 破棄に失敗した場合はフォークを取り消すこと
+	st := &failDeleteStorage{MemStorage: NewMemStorage()}
+	c, err := NewWithStorage(st, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		tx, err := c.NewTransaction(WithName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Release()
+	}
+	st.fail = "a"
+	c.SetRetention(KeepGenerations(1))
+	if _, err = c.Fork("b", WithName("fork")); err == nil {
+		t.Fatalf("%s = %v, want error", "Fork", err)
+	}
+	ro, err := OpenReadOnly(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro.findTx("fork") != nil || c.findTx("fork") != nil {
+		t.Errorf("%s = %v, want removed", "fork", ro.Trans)
+	}
+}
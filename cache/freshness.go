@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristicStatus は RFC 9111 で鮮度を推測してよいステータスコードです.
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Reuse は他のトランザクションから RFC 9111 に従って再利用できるキャッシュファイルを探し
+// f のトランザクションに複製します.
+//
+// 同じリクエストのキャッシュファイルを新しいトランザクションから順に探し
+// 最初に見つかったものが時刻 now において新鮮であれば複製して true を返します.
+// 鮮度は Cache-Control, Expires, Age と Last-Modified から計算し
+// Vary で指定されたリクエストヘッダが記録した時と一致することも確認します.
+// 取得日時は元のまま記録するため 複製したキャッシュファイルの経過時間は引き継がれます.
+//
+// 中断したトランザクションは対象にしません.
+// 管理ファイルは読み込み直さないため 他のプロセスが作成したトランザクションは
+// Find などで管理ファイルを読み込むまで対象になりません.
+// 封印されたトランザクションには複製できず ErrSealed を返します.
+func (f *File) Reuse(now time.Time) (bool, error) {
+	if f.tx.IsSealed() {
+		return false, fmt.Errorf("reuse %q in transaction %q: %w", f.creq.Url, f.tx.Name, ErrSealed)
+	}
+	if f.tx.c.ro {
+		return false, fmt.Errorf("reuse %q in transaction %q: %w", f.creq.Url, f.tx.Name, errReadOnly)
+	}
+	if m := f.creq.Method; m != http.MethodGet && m != http.MethodHead {
+		return false, nil
+	}
+	// 取得の度に管理ファイルを読み込まないよう 読み込み済みのトランザクションから探す
+	txs := f.tx.c.snapshot(func(tx *Tx) bool {
+		return tx.Name != f.tx.Name && tx.State() != StatusAborted
+	})
+	for _, tx := range txs {
+		// 破棄されている途中のトランザクションなど 読み込めないものは飛ばす
+		src := (&File{f.creq, tx, f.name}).source()
+		if src == nil {
+			continue
+		}
+		ent, fresh, err := src.fresh(f.name, f.creq, now)
+		if err != nil {
+			continue
+		}
+		if !fresh {
+			return false, nil
+		}
+		return true, f.copyFrom(src, ent)
+	}
+	return false, nil
+}
+
+// copyFrom はトランザクション src のキャッシュファイルを複製し ent をマニフェストに記録します.
+func (f *File) copyFrom(src *Tx, ent Entry) error {
+	r, err := src.st.Get(src.Name, f.name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err = f.tx.st.Put(f.tx.Name, f.name, r); err != nil {
+		return err
+	}
+	return f.tx.putEntry(ent)
+}
+
+// fresh はキャッシュファイル name が時刻 now においてリクエスト creq に対して
+// 新鮮なまま再利用できるかを返します.
+func (tx *Tx) fresh(name string, creq *cReq, now time.Time) (Entry, bool, error) {
+	ent, ok, err := tx.Entry(name)
+	if err != nil || !ok {
+		return ent, false, err
+	}
+	if ent.Error != "" {
+		return ent, false, nil
+	}
+	stored, cres, body, err := tx.load(name)
+	if err != nil {
+		return ent, false, err
+	}
+	body.Close()
+	return ent, isFresh(creq, stored, cres, ent.FetchAt, now), nil
+}
+
+// isFresh は記録したリクエスト stored とレスポンス cres が
+// 時刻 now においてリクエスト req に対して新鮮かを返します.
+//
+// 再検証はしないため no-cache が指定されている場合は新鮮でないものとします.
+func isFresh(req, stored *cReq, cres *cRes, fetchAt, now time.Time) bool {
+	reqcc := parseCacheControl(req.Header)
+	if reqcc.has("no-store") || reqcc.has("no-cache") {
+		return false
+	}
+	if _, ok := req.Header["Cache-Control"]; !ok && strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache") {
+		return false
+	}
+	rescc := parseCacheControl(cres.Header)
+	if rescc.has("no-store") || rescc.has("no-cache") {
+		return false
+	}
+	if !varyMatch(cres.Header, req.Header, stored.Header) {
+		return false
+	}
+
+	lifetime, ok := freshnessLifetime(cres, rescc, fetchAt)
+	if !ok {
+		return false
+	}
+	age := currentAge(cres.Header, fetchAt, now)
+	if d, ok := reqcc.seconds("max-age"); ok && age > d {
+		return false
+	}
+	if d, ok := reqcc.seconds("min-fresh"); ok {
+		age += d
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// 鮮度が切れていてもクライアントが許容する範囲であれば再利用する
+	if v, ok := reqcc["max-stale"]; ok && !rescc.has("must-revalidate") {
+		if v == "" {
+			return true
+		}
+		d, ok := reqcc.seconds("max-stale")
+		return ok && age < lifetime+d
+	}
+	return false
+}
+
+// freshnessLifetime はレスポンスが新鮮である期間を返します.
+//
+// 期間を明示も推測もできない場合は false を返します.
+func freshnessLifetime(cres *cRes, cc cacheControl, fetchAt time.Time) (time.Duration, bool) {
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+	date := headerTime(cres.Header, "Date", fetchAt)
+	if v := cres.Header.Get("Expires"); v != "" {
+		// 不正な Expires は既に期限切れとして扱う
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			return 0, true
+		}
+		return exp.Sub(date), true
+	}
+	if !heuristicStatus[cres.StatusCode] && !cc.has("public") {
+		return 0, false
+	}
+	// Last-Modified からの経過時間の 10% を推測値とする
+	lm := headerTime(cres.Header, "Last-Modified", time.Time{})
+	if lm.IsZero() || !lm.Before(date) {
+		return 0, false
+	}
+	return date.Sub(lm) / 10, true
+}
+
+// currentAge は時刻 now におけるレスポンスの経過時間を返します.
+func currentAge(h http.Header, fetchAt, now time.Time) time.Duration {
+	age := fetchAt.Sub(headerTime(h, "Date", fetchAt))
+	if age < 0 {
+		age = 0
+	}
+	if n, err := strconv.ParseInt(strings.TrimSpace(h.Get("Age")), 10, 64); err == nil {
+		if d := time.Duration(n) * time.Second; d > age {
+			age = d
+		}
+	}
+	return age + now.Sub(fetchAt)
+}
+
+// headerTime はヘッダ name の日時を返します.
+//
+// ヘッダがないか解析できない場合は def を返します.
+func headerTime(h http.Header, name string, def time.Time) time.Time {
+	t, err := http.ParseTime(h.Get(name))
+	if err != nil {
+		return def
+	}
+	return t
+}
+
+// varyMatch はレスポンスの Vary で指定されたリクエストヘッダが
+// req と stored で一致するかを返します.
+func varyMatch(h, req, stored http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return false
+			}
+			if name != "" && headerValue(req, name) != headerValue(stored, name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// headerValue は比較のためにヘッダ name の値を 1 つに連結して返します.
+func headerValue(h http.Header, name string) string {
+	var vs []string
+	for _, v := range h.Values(name) {
+		vs = append(vs, strings.TrimSpace(v))
+	}
+	return strings.Join(vs, ",")
+}
+
+// cacheControl は Cache-Control ヘッダの指示子と値を表します.
+type cacheControl map[string]string
+
+// parseCacheControl は Cache-Control ヘッダを解析します.
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values("Cache-Control") {
+		for _, dir := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(dir), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// has は指示子 name があるかを返します.
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds は指示子 name の値を秒数として返します.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsFresh(t *testing.T) {
+	fetchAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	date := fetchAt.Format(http.TimeFormat)
+	tests := []struct {
+		name   string
+		req    http.Header
+		res    http.Header
+		status int
+		after  time.Duration
+		want   bool
+	}{
+		{"max-age", nil, http.Header{"Cache-Control": {"max-age=60"}}, 200, 30 * time.Second, true},
+		{"max-age expired", nil, http.Header{"Cache-Control": {"max-age=60"}}, 200, 90 * time.Second, false},
+		{"age", nil, http.Header{"Cache-Control": {"max-age=60"}, "Age": {"50"}}, 200, 30 * time.Second, false},
+		{"expires", nil, http.Header{"Date": {date}, "Expires": {fetchAt.Add(time.Hour).Format(http.TimeFormat)}}, 200, time.Minute, true},
+		{"invalid expires", nil, http.Header{"Expires": {"0"}}, 200, 0, false},
+		{"no-store", nil, http.Header{"Cache-Control": {"no-store, max-age=60"}}, 200, 0, false},
+		{"no-cache", nil, http.Header{"Cache-Control": {"no-cache"}, "Expires": {"0"}}, 200, 0, false},
+		{"heuristic", nil, http.Header{"Date": {date}, "Last-Modified": {fetchAt.Add(-100 * time.Hour).Format(http.TimeFormat)}}, 200, 9 * time.Hour, true},
+		{"heuristic expired", nil, http.Header{"Date": {date}, "Last-Modified": {fetchAt.Add(-100 * time.Hour).Format(http.TimeFormat)}}, 200, 11 * time.Hour, false},
+		{"heuristic status", nil, http.Header{"Date": {date}, "Last-Modified": {fetchAt.Add(-100 * time.Hour).Format(http.TimeFormat)}}, 500, 0, false},
+		{"no validator", nil, http.Header{"Date": {date}}, 200, 0, false},
+		{"vary match", http.Header{"Accept-Language": {"ja"}}, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, 200, 0, true},
+		{"vary mismatch", http.Header{"Accept-Language": {"en"}}, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, 200, 0, false},
+		{"vary *", http.Header{"Accept-Language": {"ja"}}, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, 200, 0, false},
+		{"request no-cache", http.Header{"Cache-Control": {"no-cache"}}, http.Header{"Cache-Control": {"max-age=60"}}, 200, 0, false},
+		{"request pragma", http.Header{"Pragma": {"no-cache"}}, http.Header{"Cache-Control": {"max-age=60"}}, 200, 0, false},
+		{"request max-age", http.Header{"Cache-Control": {"max-age=10"}}, http.Header{"Cache-Control": {"max-age=60"}}, 200, 30 * time.Second, false},
+		{"request min-fresh", http.Header{"Cache-Control": {"min-fresh=40"}}, http.Header{"Cache-Control": {"max-age=60"}}, 200, 30 * time.Second, false},
+		{"request max-stale", http.Header{"Cache-Control": {"max-stale=60"}}, http.Header{"Cache-Control": {"max-age=60"}}, 200, 90 * time.Second, true},
+		{"must-revalidate", http.Header{"Cache-Control": {"max-stale"}}, http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}, 200, 90 * time.Second, false},
+	}
+	stored := &cReq{Method: http.MethodGet, Header: http.Header{"Accept-Language": {"ja"}}}
+	for _, tt := range tests {
+		req := &cReq{Method: http.MethodGet, Header: tt.req}
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		cres := &cRes{StatusCode: tt.status, Header: tt.res}
+		if got := isFresh(req, stored, cres, fetchAt, fetchAt.Add(tt.after)); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReuse(t *testing.T) {
+	c := NewMemory(5)
+	old, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := func(tx *Tx, url, cc, body string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		cf, err := tx.NewFile(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cf.Store(&http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Cache-Control": {cc}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store(old, "https://example.com/fresh", "max-age=3600", "fresh")
+	store(old, "https://example.com/stale", "max-age=0", "stale")
+	if err = old.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, tt := range []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/fresh", true},
+		{"https://example.com/stale", false},
+		{"https://example.com/none", false},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		cf, err := cur.NewFile(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := cf.Reuse(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want || cf.IsExists() != tt.want {
+			t.Errorf("%s = %v, want %v", tt.url, ok, tt.want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/fresh", nil)
+	if got := loadBody(t, cur, req); got != "fresh" {
+		t.Errorf("%s = %q, want %q", "body", got, "fresh")
+	}
+	// 取得日時は元のエントリを引き継ぐこと
+	oents, _ := old.Entries()
+	cents, err := cur.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cents) != 1 || !cents[0].FetchAt.Equal(oents[0].FetchAt) {
+		t.Errorf("%s = %+v, want %+v", "entries", cents, oents[:1])
+	}
+}
+
+func TestReuseWhileRefreshing(t *testing.T) {
+	// 管理ファイルを読み込み直して封印の状態が変わる間も競合せずに保存や複製ができ
+	// 最後に封印されれば ErrSealed を返すこと
+	c := NewMemory(5)
+	cur, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	cf, err := cur.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			c.mu.Lock()
+			src := *cur
+			src.Sealed = i%2 == 1
+			cur.refresh(&src)
+			c.mu.Unlock()
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		cf.Reuse(time.Now())
+		cf.Store(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody})
+	}
+	<-done
+	if _, err = cf.Reuse(time.Now()); !errors.Is(err, ErrSealed) {
+		t.Errorf("%s = %v, want %v", "reuse", err, ErrSealed)
+	}
+}
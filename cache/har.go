@@ -0,0 +1,400 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// harVersion は出力する HAR のバージョンです.
+const harVersion = "1.2"
+
+// har は HAR 1.2 のルートオブジェクトを表します.
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Redirect        bool        `json:"_redirect,omitempty"` // リダイレクトの経過
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Error       *NetError      `json:"_error,omitempty"` // 記録したネットワークエラー
+}
+
+type harCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked,omitempty"`
+	DNS     float64 `json:"dns,omitempty"`
+	Connect float64 `json:"connect,omitempty"`
+	SSL     float64 `json:"ssl,omitempty"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// modulePath は go-crawlb のモジュールパスです.
+const modulePath = "github.com/17e10/go-crawlb"
+
+// toolVersion は実行中のプログラムに組み込まれた go-crawlb のバージョンを返します.
+//
+// ビルド情報から分からない場合は "(devel)" を返します.
+func toolVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Path == modulePath && bi.Main.Version != "" {
+			return bi.Main.Version
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				return dep.Version
+			}
+		}
+	}
+	return "(devel)"
+}
+
+// ExportHAR はトランザクションに記録されたリクエストを HAR 1.2 形式で w に出力します.
+//
+// テキストでないボディは base64 でエンコードします.
+func (tx *Tx) ExportHAR(w io.Writer) error {
+	ents, err := tx.AllEntries()
+	if err != nil {
+		return err
+	}
+
+	h := har{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "go-crawlb", Version: toolVersion()},
+		Entries: make([]harEntry, 0, len(ents)),
+	}}
+	for _, ent := range ents {
+		hes, err := tx.harEntries(ent)
+		if err != nil {
+			return err
+		}
+		h.Log.Entries = append(h.Log.Entries, hes...)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&h)
+}
+
+// harEntries はエントリを HAR のエントリに変換します.
+//
+// リダイレクトされた場合は経過をそれぞれ redirectURL を持つエントリとして最後のエントリの前に並べます.
+func (tx *Tx) harEntries(ent Entry) ([]harEntry, error) {
+	src := (&File{tx: tx, name: ent.Name}).source()
+	if src == nil {
+		return nil, notExist("export", tx.Name, ent.Name)
+	}
+	creq, cres, r, err := src.load(ent.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	resp := cres.newResponse()
+	if resp.Proto == "" {
+		resp.Proto = "HTTP/1.1"
+	}
+
+	hes := make([]harEntry, 0, len(cres.Redirects)+1)
+	payload := creq.body()
+	for _, hop := range cres.Redirects {
+		statusText := strings.TrimSpace(strings.TrimPrefix(hop.Status, strconv.Itoa(hop.StatusCode)))
+		hes = append(hes, harEntry{
+			StartedDateTime: ent.FetchAt,
+			Request:         harReq(hop.Method, hop.Url, creq.Header, payload),
+			Response: harResponse{
+				Status:      hop.StatusCode,
+				StatusText:  statusText,
+				HTTPVersion: resp.Proto,
+				Cookies:     harCookies((&http.Response{Header: hop.Header}).Cookies()),
+				Headers:     harHeaders(hop.Header),
+				Content:     harContent{MimeType: hop.Header.Get("Content-Type")},
+				RedirectURL: hop.Header.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+			Redirect: true,
+		})
+		// 最初のリクエストのみボディを持つものとする
+		payload = nil
+	}
+
+	method, u := creq.Method, creq.Url
+	if cres.Url != "" {
+		method, u = cres.Method, cres.Url
+	}
+	statusText := strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+	hresp := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  statusText,
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		Content: harContent{
+			Size:     int64(len(body)),
+			MimeType: resp.Header.Get("Content-Type"),
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	hresp.Error = cres.Error
+	if IsText(resp.Header) && utf8.Valid(body) {
+		hresp.Content.Text = string(body)
+	} else if len(body) > 0 {
+		hresp.Content.Text = base64.StdEncoding.EncodeToString(body)
+		hresp.Content.Encoding = "base64"
+	}
+
+	he := harEntry{
+		StartedDateTime: ent.FetchAt,
+		Request:         harReq(method, u, creq.Header, payload),
+		Response:        hresp,
+	}
+	if t := ent.Timing; t != nil {
+		ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+		he.Time = ms(t.Wait + t.Total)
+		he.Timings = harTimings{
+			Blocked: ms(t.Wait),
+			DNS:     ms(t.DNS),
+			Connect: ms(t.Connect + t.TLS), // HAR の connect は ssl を含む
+			SSL:     ms(t.TLS),
+			Send:    0,
+			Wait:    ms(t.FirstByte - t.DNS - t.Connect - t.TLS),
+			Receive: ms(t.Total - t.FirstByte),
+		}
+	}
+	return append(hes, he), nil
+}
+
+// harReq はリクエストを HAR のリクエストに変換します.
+func harReq(method, rawURL string, header http.Header, payload []byte) harRequest {
+	hreq := harRequest{
+		Method:      method,
+		URL:         rawURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     harCookies((&http.Request{Header: header}).Cookies()),
+		Headers:     harHeaders(header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		for name, vals := range u.Query() {
+			for _, v := range vals {
+				hreq.QueryString = append(hreq.QueryString, harNameValue{name, v})
+			}
+		}
+	}
+	if payload != nil {
+		hreq.PostData = &harPostData{MimeType: header.Get("Content-Type"), Text: string(payload)}
+		hreq.BodySize = len(payload)
+	}
+	return hreq
+}
+
+// ImportHAR は HAR 形式のリクエストとレスポンスをトランザクションに保存します.
+//
+// ブラウザで記録した HAR からトランザクションを作成し ログインの手順などを再生する場合に利用します.
+// 同じリクエストが複数含まれる場合は後のものを保存します.
+// ExportHAR が出力したリダイレクトの経過は続くエントリと合わせて最初のリクエストに保存します.
+func (tx *Tx) ImportHAR(r io.Reader) error {
+	var h har
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return err
+	}
+	var hops []*harEntry
+	for i := range h.Log.Entries {
+		he := &h.Log.Entries[i]
+		if he.Redirect {
+			hops = append(hops, he)
+			continue
+		}
+		if err := tx.importHarEntry(he, hops); err != nil {
+			return fmt.Errorf("import har entry #%d: %w", i, err)
+		}
+		hops = nil
+	}
+	return nil
+}
+
+// importHarEntry は HAR のエントリをトランザクションに保存します.
+//
+// hops はエントリに至るまでのリダイレクトの経過です.
+func (tx *Tx) importHarEntry(he *harEntry, hops []*harEntry) error {
+	first := he
+	if len(hops) > 0 {
+		first = hops[0]
+	}
+	var body io.Reader
+	if first.Request.PostData != nil {
+		body = strings.NewReader(first.Request.PostData.Text)
+	}
+	req, err := http.NewRequest(first.Request.Method, first.Request.URL, body)
+	if err != nil {
+		return err
+	}
+	for _, h := range first.Request.Headers {
+		if !strings.HasPrefix(h.Name, ":") { // HTTP/2 の疑似ヘッダは除く
+			req.Header.Add(h.Name, h.Value)
+		}
+	}
+
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		return err
+	}
+	fetchAt := he.StartedDateTime
+	if fetchAt.IsZero() {
+		fetchAt = time.Now()
+	}
+	if ne := he.Response.Error; ne != nil {
+		return cf.storeCres(&cRes{ContentLength: -1, Error: ne}, http.NoBody, fetchAt, nil)
+	}
+
+	content := []byte(he.Response.Content.Text)
+	if he.Response.Content.Encoding == "base64" {
+		if content, err = base64.StdEncoding.DecodeString(he.Response.Content.Text); err != nil {
+			return err
+		}
+	}
+	resp := &http.Response{
+		Status:        strings.TrimSpace(fmt.Sprintf("%d %s", he.Response.Status, he.Response.StatusText)),
+		StatusCode:    he.Response.Status,
+		Proto:         he.Response.HTTPVersion,
+		Header:        make(http.Header),
+		ContentLength: int64(len(content)),
+		Body:          io.NopCloser(bytes.NewReader(content)),
+	}
+	resp.ProtoMajor, resp.ProtoMinor, _ = http.ParseHTTPVersion(resp.Proto)
+	for _, h := range he.Response.Headers {
+		if strings.EqualFold(h.Name, "Content-Encoding") || strings.EqualFold(h.Name, "Content-Length") {
+			// HAR の content.text は展開済みのため ボディと一致しないヘッダは除く
+			continue
+		}
+		resp.Header.Add(h.Name, h.Value)
+	}
+
+	// newCres がリダイレクトの経過を記録できるようにリクエストを繋げる
+	last := req
+	for i, hop := range hops {
+		next := he
+		if i+1 < len(hops) {
+			next = hops[i+1]
+		}
+		u, err := url.Parse(next.Request.URL)
+		if err != nil {
+			return err
+		}
+		hresp := &http.Response{
+			Status:     strings.TrimSpace(fmt.Sprintf("%d %s", hop.Response.Status, hop.Response.StatusText)),
+			StatusCode: hop.Response.Status,
+			Header:     make(http.Header),
+			Request:    last,
+		}
+		for _, h := range hop.Response.Headers {
+			hresp.Header.Add(h.Name, h.Value)
+		}
+		last = &http.Request{Method: next.Request.Method, URL: u, Response: hresp}
+	}
+	if len(hops) > 0 {
+		resp.Request = last
+	}
+
+	return cf.store(resp, fetchAt)
+}
+
+// harHeaders は http.Header を HAR のヘッダに変換します.
+func harHeaders(h http.Header) []harNameValue {
+	hs := []harNameValue{}
+	for name, vals := range h {
+		for _, v := range vals {
+			hs = append(hs, harNameValue{name, v})
+		}
+	}
+	return hs
+}
+
+// harCookies は http.Cookie を HAR のクッキーに変換します.
+func harCookies(cookies []*http.Cookie) []harCookie {
+	cs := []harCookie{}
+	for _, c := range cookies {
+		cs = append(cs, harCookie{c.Name, c.Value})
+	}
+	return cs
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	c := NewMemory(3)
+	src, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := func(req *http.Request, ctype string, body []byte) {
+		cf, err := src.NewFile(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cf.Store(&http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			Header:     http.Header{"Content-Type": {ctype}, "Set-Cookie": {"sid=1"}},
+			Body:       io.NopCloser(bytes.NewReader(body)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	get, _ := http.NewRequest(http.MethodGet, "https://example.com/?q=1", nil)
+	post, _ := http.NewRequest(http.MethodPost, "https://example.com/login", strings.NewReader("user=a"))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	img, _ := http.NewRequest(http.MethodGet, "https://example.com/a.png", nil)
+	store(get, "text/html; charset=utf-8", []byte("<p>hello</p>"))
+	store(post, "application/json", []byte(`{"ok":true}`))
+	store(img, "image/png", []byte{0x89, 'P', 'N', 'G', 0, 0xff})
+
+	var buf bytes.Buffer
+	if err = src.ExportHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var h har
+	if err = json.Unmarshal(buf.Bytes(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Log.Version != "1.2" || len(h.Log.Entries) != 3 {
+		t.Fatalf("%s = %s, %d entries", "har", h.Log.Version, len(h.Log.Entries))
+	}
+	// creator.version は HAR のバージョンではなく go-crawlb のバージョンであること
+	if v := h.Log.Creator.Version; v != toolVersion() || v == h.Log.Version {
+		t.Errorf("%s = %q, want %q", "creator.version", v, toolVersion())
+	}
+	if pd := h.Log.Entries[1].Request.PostData; pd == nil || pd.Text != "user=a" || pd.MimeType != "application/x-www-form-urlencoded" {
+		t.Errorf("%s = %+v, want %q", "postData", pd, "user=a")
+	}
+	if ct := h.Log.Entries[2].Response.Content; ct.Encoding != "base64" {
+		t.Errorf("%s = %q, want %q", "binary encoding", ct.Encoding, "base64")
+	}
+
+	dst, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dst.ImportHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	sents, _ := src.Entries()
+	dents, err := dst.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dents) != len(sents) {
+		t.Fatalf("%s = %d, want %d", "len(entries)", len(dents), len(sents))
+	}
+	for i := range sents {
+		s, d := sents[i], dents[i]
+		if s.Name != d.Name || s.ContentHash != d.ContentHash || !s.FetchAt.Equal(d.FetchAt) {
+			t.Errorf("%s = %+v, want %+v", "imported entry", d, s)
+		}
+	}
+	if got := loadBody(t, dst, post); got != `{"ok":true}` {
+		t.Errorf("%s = %q, want %q", "body", got, `{"ok":true}`)
+	}
+}
+
+func TestHARRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "done") })
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := NewMemory(3)
+	src, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/a", nil)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := src.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cf.Store(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(tx *Tx) []harEntry {
+		var buf bytes.Buffer
+		if err := tx.ExportHAR(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var h har
+		if err := json.Unmarshal(buf.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+		return h.Log.Entries
+	}
+	want := []struct {
+		url      string
+		status   int
+		redirect string
+	}{
+		{ts.URL + "/a", http.StatusFound, "/b"},
+		{ts.URL + "/b", http.StatusMovedPermanently, "/c"},
+		{ts.URL + "/c", http.StatusOK, ""},
+	}
+	check := func(name string, hes []harEntry) {
+		if len(hes) != len(want) {
+			t.Fatalf("%s = %d, want %d", name, len(hes), len(want))
+		}
+		for i, w := range want {
+			he := hes[i]
+			if he.Request.URL != w.url || he.Response.Status != w.status || he.Response.RedirectURL != w.redirect || he.Redirect != (i < 2) {
+				t.Errorf("%s[%d] = %s %d %q, want %s %d %q", name, i, he.Request.URL, he.Response.Status, he.Response.RedirectURL, w.url, w.status, w.redirect)
+			}
+			// 計測していない場合も send, wait, receive は負にしないこと
+			if tm := he.Timings; tm.Send < 0 || tm.Wait < 0 || tm.Receive < 0 {
+				t.Errorf("%s[%d].timings = %+v", name, i, tm)
+			}
+		}
+	}
+	check("entries", export(src))
+
+	// 取り込むと最初のリクエストにリダイレクトの経過と共に保存すること
+	dst, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = src.ExportHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err = dst.ImportHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadBody(t, dst, req); got != "done" {
+		t.Errorf("%s = %q, want %q", "body", got, "done")
+	}
+	check("reimported", export(dst))
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// トランザクションの状態です.
+//
+// 状態を持たない古いトランザクションは StatusOpen として扱います.
+const (
+	StatusOpen      = "open"      // 記録中
+	StatusCommitted = "committed" // 全ての記録を終えた
+	StatusAborted   = "aborted"   // 記録を中断した
+)
+
+var (
+	errTxClosed = errors.New("transaction already closed")
+
+	// ErrSealed は封印されたトランザクションに保存しようとしたことを表します.
+	ErrSealed = errors.New("transaction is sealed")
+)
+
+// Stats はトランザクションの統計情報を表します.
+type Stats struct {
+	Entries  int         `json:"entries"`  // エントリ数
+	Bytes    int64       `json:"bytes"`    // ボディの合計サイズ
+	Statuses map[int]int `json:"statuses"` // ステータスコード毎のエントリ数
+}
+
+// State はトランザクションの状態を返します.
+//
+// 状態を持たない古いトランザクションは StatusOpen を返します.
+// 他の goroutine が管理ファイルを読み込み直している間も参照できます.
+func (tx *Tx) State() string {
+	tx.smu.RLock()
+	defer tx.smu.RUnlock()
+	if tx.Status == "" {
+		return StatusOpen
+	}
+	return tx.Status
+}
+
+// IsSealed はトランザクションが封印されているかを返します.
+//
+// 他の goroutine が管理ファイルを読み込み直している間も参照できます.
+func (tx *Tx) IsSealed() bool {
+	tx.smu.RLock()
+	defer tx.smu.RUnlock()
+	return tx.Sealed
+}
+
+// IsOpen はトランザクションが記録中かを返します.
+func (tx *Tx) IsOpen() bool {
+	return tx.State() == StatusOpen
+}
+
+// Commit はトランザクションの記録を完了し 統計情報と共に管理ファイルに記録します.
+//
+// コミットしたトランザクションは封印され 以降は保存できなくなります.
+func (tx *Tx) Commit() error {
+	stats, err := tx.stats()
+	if err != nil {
+		return err
+	}
+	return tx.close("commit", StatusCommitted, stats)
+}
+
+// Seal はトランザクションを封印し 読み取り専用にします.
+//
+// 封印されたトランザクションは記録された結果を変更されないため
+// 過去の障害を再現する際に証拠を書き換えてしまうことがありません.
+func (tx *Tx) Seal() error {
+	return tx.c.update(func() error {
+		if tx.c.findTx(tx.Name) != tx {
+			return fmt.Errorf("seal transaction %q: %w", tx.Name, errNoSuchTx)
+		}
+		tx.smu.Lock()
+		tx.Sealed = true
+		tx.smu.Unlock()
+		return nil
+	})
+}
+
+// Abort はトランザクションの記録を中断したことを管理ファイルに記録します.
+func (tx *Tx) Abort() error {
+	return tx.close("abort", StatusAborted, nil)
+}
+
+// close はトランザクションの状態を status に変更します.
+func (tx *Tx) close(op, status string, stats *Stats) error {
+	return tx.c.update(func() error {
+		if tx.c.findTx(tx.Name) != tx {
+			return fmt.Errorf("%s transaction %q: %w", op, tx.Name, errNoSuchTx)
+		}
+		if !tx.IsOpen() {
+			return fmt.Errorf("%s transaction %q: %w", op, tx.Name, errTxClosed)
+		}
+		tx.smu.Lock()
+		tx.Status = status
+		if status == StatusCommitted {
+			tx.Sealed = true
+		}
+		tx.smu.Unlock()
+		tx.CloseAt = time.Now().Format(createAtLayout)
+		tx.Stats = stats
+		return nil
+	})
+}
+
+// stats はマニフェストから統計情報を計算します.
+func (tx *Tx) stats() (*Stats, error) {
+	ents, err := tx.Entries()
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{Statuses: make(map[int]int)}
+	for _, ent := range ents {
+		stats.Entries++
+		stats.Bytes += ent.Size
+		stats.Statuses[ent.StatusCode]++
+	}
+	return stats, nil
+}
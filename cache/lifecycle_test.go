@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	c := NewMemory(3)
+	done, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	storeResponse(t, done, req, http.StatusOK, "hello")
+	req, _ = http.NewRequest(http.MethodGet, "https://example.com/x", nil)
+	storeResponse(t, done, req, http.StatusNotFound, "not found")
+	if err = done.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = done.Commit(); !errors.Is(err, errTxClosed) {
+		t.Errorf("%s = %v, want %v", "commit twice", err, errTxClosed)
+	}
+
+	broken, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = broken.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 管理ファイルから読み直しても状態と統計情報が得られること
+	c, err = NewWithStorage(c.st, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.GetLastTransaction(Committed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Name != done.Name || tx.Status != StatusCommitted {
+		t.Errorf("%s = %s (%s), want %s", "last committed", tx.Name, tx.Status, done.Name)
+	}
+	if s := tx.Stats; s == nil || s.Entries != 2 || s.Bytes != 14 || s.Statuses[http.StatusNotFound] != 1 {
+		t.Errorf("%s = %+v", "stats", s)
+	}
+	if tx, err = c.GetTransaction(broken.Name); err != nil || tx.Status != StatusAborted {
+		t.Errorf("%s = %v, %v, want %s", "aborted", tx, err, StatusAborted)
+	}
+	if tx, err = c.GetLastTransaction(); err != nil || !tx.IsOpen() {
+		t.Errorf("%s = %v, %v, want %s", "last", tx, err, StatusOpen)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	c := NewMemory(3)
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	storeResponse(t, tx, req, http.StatusOK, "hello")
+	if err = tx.Seal(); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cf.Store(&http.Response{StatusCode: http.StatusOK}); !errors.Is(err, ErrSealed) {
+		t.Errorf("%s = %v, want %v", "store", err, ErrSealed)
+	}
+	resp, err := cf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
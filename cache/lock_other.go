@@ -0,0 +1,33 @@
+//go:build !unix && !windows
+
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// flock は name のファイルロックを取得します.
+//
+// flock(2) も LockFileEx も使用できない環境では name を排他的に作成することでロックを表します.
+// この場合 共有ロックは常に取得できたものとして扱うため 使用中のトランザクションも破棄されることがあります.
+// プロセスが異常終了するとロックファイルが残るため 手作業で削除する必要があります.
+func flock(name string, shared, wait bool) (unlock func() error, ok bool, err error) {
+	if shared {
+		return func() error { return nil }, true, nil
+	}
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() error { return os.Remove(name) }, true, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+		if !wait {
+			return nil, false, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentTransactions(t *testing.T) {
+	const (
+		numProc = 4
+		numNew  = 5
+	)
+	dir := t.TempDir()
+
+	// プロセス毎に Cache を作成したのと同じ状況で同時にトランザクションを作成する
+	var wg sync.WaitGroup
+	errs := make(chan error, numProc*numNew)
+	for i := 0; i < numProc; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := New(dir, numProc*numNew)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 0; j < numNew; j++ {
+				tx, err := c.NewTransaction()
+				if err != nil {
+					errs <- err
+					return
+				}
+				tx.Release()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	c, err := New(dir, numProc*numNew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(c.Trans), numProc*numNew; got != want {
+		t.Errorf("%s = %d, want %d", "transactions", got, want)
+	}
+}
+
+func TestDiscardInUse(t *testing.T) {
+	dir := t.TempDir()
+
+	c1, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inuse, err := c1.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 他のプロセスが使用中のトランザクションは破棄されないこと
+	c2, err := New(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		tx, err := c2.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Release()
+	}
+	tx, err := c2.GetTransaction(inuse.Name)
+	if err != nil {
+		t.Fatalf("%s = %v, want %v", "in-use transaction", err, nil)
+	}
+	tx.Release()
+
+	// 使用を終了すると破棄されること
+	if err = inuse.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c2.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c2.GetTransaction(inuse.Name); err == nil {
+		t.Errorf("%s = %v, want %v", "released transaction", nil, errNoSuchTx)
+	}
+}
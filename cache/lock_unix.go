@@ -0,0 +1,41 @@
+//go:build unix
+
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock は name のファイルロックを取得します.
+//
+// shared が true の場合は共有ロックを false の場合は排他ロックを取得します.
+// wait が false でロックを取得できない場合 ok に false を返します.
+// ロックは unlock を呼び出すかプロセスが終了すると解放されます.
+func flock(name string, shared, wait bool) (unlock func() error, ok bool, err error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		if err = syscall.Flock(int(f.Fd()), how); err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, false, nil
+	} else if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f.Close, true, nil
+}
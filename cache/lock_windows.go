@@ -0,0 +1,55 @@
+//go:build windows
+
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// flock は name のファイルロックを取得します.
+//
+// shared が true の場合は共有ロックを false の場合は排他ロックを取得します.
+// wait が false でロックを取得できない場合 ok に false を返します.
+// ロックは unlock を呼び出すかプロセスが終了すると解放されます.
+//
+// ロックしたまま DeleteTx でロックファイルを削除できるよう FILE_SHARE_DELETE を指定して開きます.
+func flock(name string, shared, wait bool) (unlock func() error, ok bool, err error) {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, false, err
+	}
+	h, err := windows.CreateFile(p,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(h), name)
+
+	var flags uint32
+	if !shared {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	ol := new(windows.Overlapped)
+	err = windows.LockFileEx(h, flags, 0, 1, 0, ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		f.Close()
+		return nil, false, nil
+	} else if err != nil {
+		f.Close()
+		return nil, false, &os.PathError{Op: "lock", Path: name, Err: err}
+	}
+	return func() error {
+		err := windows.UnlockFileEx(h, 0, 1, 0, ol)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, true, nil
+}
@@ -0,0 +1,355 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"sort"
+	"time"
+)
+
+// manifestName はトランザクションのマニフェストを保存するエントリ名です.
+const manifestName = "manifest.json"
+
+// manifestLog はマニフェストへの変更を 1 行 1 エントリの JSON で追記するエントリ名です.
+//
+// マニフェストは manifestName の内容に manifestLog の変更を順に適用したものです.
+const manifestLog = "manifest.log"
+
+// Entry はトランザクションに記録されたリクエストを表します.
+type Entry struct {
+	Name          string    `json:"name"`                     // キャッシュファイルの名前
+	Method        string    `json:"method"`                   // リクエストメソッド
+	URL           string    `json:"url"`                      // リクエスト URL
+	PayloadDigest string    `json:"payload_digest,omitempty"` // ペイロードの SHA-256
+	StatusCode    int       `json:"status"`                   // ステータスコード
+	Size          int64     `json:"size"`                     // ボディのサイズ
+	FetchAt       time.Time `json:"fetch_at"`                 // 取得日時
+	ContentHash   string    `json:"content_hash"`             // ボディの SHA-256
+	Error         string    `json:"error,omitempty"`          // 記録したネットワークエラー
+	Timing        *Timing   `json:"timing,omitempty"`         // 所要時間と接続の情報
+}
+
+// manifest はトランザクションのマニフェストを表します.
+type manifest struct {
+	Entries []Entry `json:"entries"`
+	index   map[string]int
+	snap    Info  // 読み込んだ manifestName の情報
+	logSize int64 // 最後に確認した manifestLog の大きさ
+	logRead int64 // manifestLog から読み込んだバイト数
+}
+
+// Entries はトランザクションに記録されたリクエストを記録した順に返します.
+//
+// マニフェストがないトランザクションはキャッシュファイルを読み込んでマニフェストを作り直します.
+func (tx *Tx) Entries() ([]Entry, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	m, err := tx.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Entry(nil), m.Entries...), nil
+}
+
+// Entry は name のエントリを返します.
+func (tx *Tx) Entry(name string) (Entry, bool, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	m, err := tx.loadManifest()
+	if err != nil {
+		return Entry{}, false, err
+	}
+	i, ok := m.index[name]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	return m.Entries[i], true, nil
+}
+
+// putEntry はマニフェストにエントリを追加または更新します.
+//
+// 保存先が Appender を実装していればエントリをログに追記し
+// そうでなければ他のプロセスの変更を読み込み直してからマニフェスト全体を書き直します.
+func (tx *Tx) putEntry(ent Entry) error {
+	if a, ok := tx.st.(Appender); ok {
+		return tx.appendEntry(a, ent)
+	}
+	if l, ok := tx.st.(Locker); ok {
+		unlock, err := l.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	m, err := tx.loadManifest()
+	if err != nil {
+		return err
+	}
+	m.put(ent)
+	return tx.saveManifest()
+}
+
+// appendEntry はエントリをマニフェストのログに追記します.
+func (tx *Tx) appendEntry(a Appender, ent Entry) error {
+	b, err := json.Marshal(ent)
+	if err != nil {
+		return err
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	m, err := tx.loadManifest()
+	if err != nil {
+		return err
+	}
+	if err = a.Append(tx.Name, manifestLog, append(b, '\n')); err != nil {
+		return err
+	}
+	// 追記した行は次に読み込む時にもう一度適用されるが 結果は変わらない
+	m.put(ent)
+	return nil
+}
+
+// loadManifest はマニフェストを読み込みます.
+// 呼び出し元は tx.mu をロックしている必要があります.
+//
+// 読み込み済みの場合も 他のプロセスが書き直したり追記した変更を取り込みます.
+func (tx *Tx) loadManifest() (*manifest, error) {
+	snap, err := tx.st.Stat(tx.Name, manifestName)
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+	m := tx.manifest
+	if m == nil || snap.Size != m.snap.Size || !snap.ModTime.Equal(m.snap.ModTime) {
+		if m, err = tx.readManifest(true); err != nil {
+			return nil, err
+		}
+		m.snap = snap
+		tx.manifest = m
+		return m, nil
+	}
+	if err = tx.readLog(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readManifest は manifestName と manifestLog からマニフェストを読み込みます.
+//
+// どちらもない場合 rebuild が true ならキャッシュファイルから作り直し false なら nil を返します.
+func (tx *Tx) readManifest(rebuild bool) (*manifest, error) {
+	m := &manifest{}
+	r, err := tx.st.Get(tx.Name, manifestName)
+	if isNotExist(err) {
+		if _, err = tx.st.Stat(tx.Name, manifestLog); isNotExist(err) {
+			if !rebuild {
+				return nil, nil
+			}
+			if m, err = tx.rebuildManifest(); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		err = json.NewDecoder(r).Decode(m)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.reindex()
+	if err = tx.readLog(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readLog は manifestLog に追記された変更のうち まだ読み込んでいないものを適用します.
+//
+// 書き込み途中の最後の行は次に読み込む時に適用します.
+func (tx *Tx) readLog(m *manifest) error {
+	info, err := tx.st.Stat(tx.Name, manifestLog)
+	if isNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Size == m.logSize {
+		return nil
+	}
+	r, err := tx.st.Get(tx.Name, manifestLog)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(m.logRead, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, r, m.logRead)
+	}
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		m.logRead += int64(len(line))
+		var ent Entry
+		if json.Unmarshal(line, &ent) == nil {
+			m.put(ent)
+		}
+	}
+	m.logSize = info.Size
+	return nil
+}
+
+// saveManifest はマニフェスト全体を manifestName に保存し manifestLog を削除します.
+// 呼び出し元は tx.mu をロックしている必要があり
+// 他のプロセスが同時に追記しないよう排他制御している必要があります.
+func (tx *Tx) saveManifest() error {
+	b, err := json.MarshalIndent(tx.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = tx.st.Put(tx.Name, manifestName, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	if err = tx.st.Delete(tx.Name, manifestLog); err != nil && !isNotExist(err) {
+		return err
+	}
+	tx.manifest.logSize, tx.manifest.logRead = 0, 0
+	tx.manifest.snap, err = tx.st.Stat(tx.Name, manifestName)
+	return err
+}
+
+// rebuildManifest はキャッシュファイルを読み込んでマニフェストを作成します.
+func (tx *Tx) rebuildManifest() (*manifest, error) {
+	m := &manifest{}
+	infos, err := tx.st.List(tx.Name)
+	if isNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if !isEntryName(info.Name) {
+			continue
+		}
+		ent, err := tx.scanEntry(info)
+		if err != nil {
+			// 読み込めないキャッシュファイルはマニフェストに含めない
+			continue
+		}
+		m.Entries = append(m.Entries, ent)
+	}
+	sort.SliceStable(m.Entries, func(i, j int) bool {
+		return m.Entries[i].FetchAt.Before(m.Entries[j].FetchAt)
+	})
+	return m, nil
+}
+
+// scanEntry はキャッシュファイルを読み込んで Entry を作成します.
+func (tx *Tx) scanEntry(info Info) (Entry, error) {
+	creq, cres, body, err := tx.load(info.Name)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer body.Close()
+	dw := newDigestWriter()
+	if _, err = io.Copy(dw, body); err != nil {
+		return Entry{}, err
+	}
+	return newEntry(info.Name, creq, cres, dw, info.ModTime), nil
+}
+
+// newEntry は保存したキャッシュファイルの Entry を作成します.
+func newEntry(name string, creq *cReq, cres *cRes, body *digestWriter, fetchAt time.Time) Entry {
+	ent := Entry{
+		Name:        name,
+		Method:      creq.Method,
+		URL:         creq.Url,
+		StatusCode:  cres.StatusCode,
+		Size:        body.n,
+		FetchAt:     fetchAt,
+		ContentHash: body.digest(),
+		Timing:      cres.Timing,
+	}
+	if creq.Payload != nil {
+		sum := sha256.Sum256(creq.Payload)
+		ent.PayloadDigest = hex.EncodeToString(sum[:])
+	}
+	if cres.Error != nil {
+		ent.Error = cres.Error.Error()
+	}
+	return ent
+}
+
+// put はエントリを追加または更新します.
+func (m *manifest) put(ent Entry) {
+	if i, ok := m.index[ent.Name]; ok {
+		m.Entries[i] = ent
+		return
+	}
+	m.index[ent.Name] = len(m.Entries)
+	m.Entries = append(m.Entries, ent)
+}
+
+// reindex はエントリ名の索引を作り直します.
+func (m *manifest) reindex() {
+	m.index = make(map[string]int, len(m.Entries))
+	for i, ent := range m.Entries {
+		m.index[ent.Name] = i
+	}
+}
+
+// isEntryName は name がキャッシュファイルの名前かを返します.
+func isEntryName(name string) bool {
+	if len(name) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// digestWriter は書き込まれたバイト数と SHA-256 を計算する io.Writer です.
+type digestWriter struct {
+	h hash.Hash
+	n int64
+}
+
+// newDigestWriter は新しい digestWriter を作成します.
+func newDigestWriter() *digestWriter {
+	return &digestWriter{h: sha256.New()}
+}
+
+// Write は p のバイト数と SHA-256 を計算します.
+func (dw *digestWriter) Write(p []byte) (int, error) {
+	dw.n += int64(len(p))
+	return dw.h.Write(p)
+}
+
+// digest は SHA-256 を 16 進数で返します.
+func (dw *digestWriter) digest() string {
+	return hex.EncodeToString(dw.h.Sum(nil))
+}
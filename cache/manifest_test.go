@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// storeResponse は req に対するレスポンスとして body を tx に保存します.
+func storeResponse(t *testing.T, tx *Tx, req *http.Request, code int, body string) *File {
+	t.Helper()
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cf.Store(&http.Response{
+		Status:     http.StatusText(code),
+		StatusCode: code,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cf
+}
+
+func TestManifest(t *testing.T) {
+	c := NewMemory(3)
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get, _ := http.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	post, _ := http.NewRequest(http.MethodPost, "https://example.com/b", strings.NewReader("q=1"))
+	storeResponse(t, tx, get, http.StatusOK, "first")
+	storeResponse(t, tx, post, http.StatusNotFound, "missing")
+	storeResponse(t, tx, get, http.StatusOK, "second")
+
+	check := func(name string) {
+		ents, err := tx.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ents) != 2 {
+			t.Fatalf("%s: %s = %d, want %d", name, "len(entries)", len(ents), 2)
+		}
+		if ents[0].Method != http.MethodGet {
+			// 作り直したマニフェストは保存日時の順に並ぶ
+			ents[0], ents[1] = ents[1], ents[0]
+		}
+		sum := sha256.Sum256([]byte("second"))
+		if ent := ents[0]; ent.URL != "https://example.com/a" || ent.Size != 6 ||
+			ent.ContentHash != hex.EncodeToString(sum[:]) || ent.PayloadDigest != "" {
+			t.Errorf("%s: %s = %+v", name, "entries[0]", ent)
+		}
+		if ent := ents[1]; ent.Method != http.MethodPost || ent.StatusCode != http.StatusNotFound ||
+			ent.PayloadDigest == "" || ent.FetchAt.IsZero() {
+			t.Errorf("%s: %s = %+v", name, "entries[1]", ent)
+		}
+	}
+	check("stored")
+
+	// マニフェストがなくてもキャッシュファイルから作り直せること
+	for _, name := range []string{manifestName, manifestLog} {
+		if err = c.st.Delete(tx.Name, name); err != nil && !isNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+	tx.manifest = nil
+	check("rebuilt")
+}
+
+func TestManifestShared(t *testing.T) {
+	key := make([]byte, 32)
+	for _, tt := range []struct {
+		name string
+		st   func(dir string) Storage
+	}{
+		{"dir", func(dir string) Storage { return NewDirStorage(dir) }},
+		{"encrypted", func(dir string) Storage {
+			st, err := NewEncryptedStorage(NewDirStorage(dir), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return st
+		}},
+	} {
+		// 同じディレクトリを別のプロセスから使用する場合を模す
+		dir := t.TempDir()
+		c1, err := NewWithStorage(tt.st(dir), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c2, err := NewWithStorage(tt.st(dir), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx1, err := c1.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx2, err := c2.GetTransaction(tx1.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, tx := range []*Tx{tx1, tx2, tx1} {
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/"+strings.Repeat("a", i+1), nil)
+			storeResponse(t, tx, req, http.StatusOK, "body")
+		}
+		for _, tx := range []*Tx{tx1, tx2} {
+			ents, err := tx.Entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ents) != 3 {
+				t.Errorf("%s: %s = %d, want %d", tt.name, "len(entries)", len(ents), 3)
+			}
+		}
+		tx1.Release()
+		tx2.Release()
+	}
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemStorage はメモリ上に保存する Storage です.
+//
+// プロセスが終了すると内容は失われるため 主にユニットテストで使用します.
+type MemStorage struct {
+	mu   sync.Mutex
+	meta []byte
+	txs  map[string]map[string]memEntry
+}
+
+// memEntry は MemStorage のエントリを表します.
+type memEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemStorage は新しい MemStorage を作成します.
+func NewMemStorage() *MemStorage {
+	return &MemStorage{txs: make(map[string]map[string]memEntry)}
+}
+
+// GetMeta は管理情報を読み込みます.
+func (s *MemStorage) GetMeta() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.meta == nil {
+		return nil, notExist("get", "", ctlname)
+	}
+	return bytes.Clone(s.meta), nil
+}
+
+// PutMeta は管理情報を保存します.
+func (s *MemStorage) PutMeta(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta = bytes.Clone(b)
+	return nil
+}
+
+// Get はエントリを読み込みます.
+func (s *MemStorage) Get(tx, name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ent, ok := s.txs[tx][name]
+	if !ok {
+		return nil, notExist("get", tx, name)
+	}
+	return io.NopCloser(bytes.NewReader(ent.data)), nil
+}
+
+// Put はエントリを保存します.
+func (s *MemStorage) Put(tx, name string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ents, ok := s.txs[tx]
+	if !ok {
+		ents = make(map[string]memEntry)
+		s.txs[tx] = ents
+	}
+	ents[name] = memEntry{b, time.Now()}
+	return nil
+}
+
+// Append はエントリの末尾に b を追記します.
+func (s *MemStorage) Append(tx, name string, b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ents, ok := s.txs[tx]
+	if !ok {
+		ents = make(map[string]memEntry)
+		s.txs[tx] = ents
+	}
+	// 読み込み中の Get は元の長さまでしか参照しないため そのまま追記できる
+	ents[name] = memEntry{append(ents[name].data, b...), time.Now()}
+	return nil
+}
+
+// Stat はエントリの情報を返します.
+func (s *MemStorage) Stat(tx, name string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ent, ok := s.txs[tx][name]
+	if !ok {
+		return Info{}, notExist("stat", tx, name)
+	}
+	return Info{name, int64(len(ent.data)), ent.modTime}, nil
+}
+
+// List はトランザクションに含まれるエントリの一覧を返します.
+func (s *MemStorage) List(tx string) ([]Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ents, ok := s.txs[tx]
+	if !ok {
+		return nil, notExist("list", tx, "")
+	}
+	infos := make([]Info, 0, len(ents))
+	for name, ent := range ents {
+		infos = append(infos, Info{name, int64(len(ent.data)), ent.modTime})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Delete はエントリを削除します.
+func (s *MemStorage) Delete(tx, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.txs[tx][name]; !ok {
+		return notExist("delete", tx, name)
+	}
+	delete(s.txs[tx], name)
+	return nil
+}
+
+// ListTx は保存先に存在するトランザクション名の一覧を返します.
+func (s *MemStorage) ListTx() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.txs))
+	for name := range s.txs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteTx はトランザクションを全てのエントリと共に削除します.
+func (s *MemStorage) DeleteTx(tx string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.txs, tx)
+	return nil
+}
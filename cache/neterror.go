@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+)
+
+// ネットワークエラーの種類です.
+const (
+	NetErrorDNS     = "dns"     // 名前解決に失敗した
+	NetErrorTimeout = "timeout" // タイムアウトした
+	NetErrorRefused = "refused" // 接続を拒否された
+	NetErrorReset   = "reset"   // 接続をリセットされた
+	NetErrorEOF     = "eof"     // 応答の途中で接続が切れた
+	NetErrorOther   = "other"   // その他の通信経路のエラー
+)
+
+// NetError はトランザクションに記録したネットワークエラーを表します.
+//
+// 再生時は記録時のエラーの代わりに返されます.
+// NetError は net.Error を実装していて 種類に応じて errors.Is で
+// syscall.ECONNREFUSED, syscall.ECONNRESET, io.ErrUnexpectedEOF, os.ErrDeadlineExceeded と
+// errors.As で *net.DNSError と判別できます.
+// errno の無い plan9 では接続の拒否とリセットを errors.Is で判別できません.
+type NetError struct {
+	Type       string `json:"type"`                   // エラーの種類
+	Message    string `json:"message"`                // 記録時のエラーメッセージ
+	Cause      string `json:"cause,omitempty"`        // 名前解決に失敗した理由
+	Name       string `json:"name,omitempty"`         // 名前解決に失敗したホスト名
+	IsNotFound bool   `json:"is_not_found,omitempty"` // ホストが見つからなかった
+	IsTimeout  bool   `json:"is_timeout,omitempty"`   // タイムアウトした
+}
+
+// NewNetError は http.Client が返したエラーから記録する NetError を作成します.
+//
+// 記録するのは名前解決の失敗 タイムアウト 接続の拒否やリセット 応答の途中での切断など
+// 通信経路で起きたエラーだけです.
+// err が nil の場合や 呼び出し元の context のキャンセルや期限切れ
+// リダイレクトの方針によるエラー 対応していないスキームなど
+// サイトの状態ではなく呼び出し元に起因するエラーは 再生すると誤った結果になるため nil を返します.
+func NewNetError(err error) *NetError {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+
+	ne := &NetError{Message: err.Error()}
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		ne.Type = NetErrorDNS
+		ne.Cause = dnsErr.Err
+		ne.Name = dnsErr.Name
+		ne.IsNotFound = dnsErr.IsNotFound
+		ne.IsTimeout = dnsErr.IsTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		ne.Type = NetErrorTimeout
+		ne.IsTimeout = true
+	case isConnError(err, errConnRefused):
+		ne.Type = NetErrorRefused
+	case isConnError(err, errConnReset):
+		ne.Type = NetErrorReset
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		ne.Type = NetErrorEOF
+	case errors.As(err, &netErr):
+		ne.Type = NetErrorOther
+	default:
+		return nil
+	}
+	return ne
+}
+
+// Error は記録時のエラーメッセージを返します.
+func (e *NetError) Error() string {
+	return e.Message
+}
+
+// Timeout はタイムアウトしたかを返します.
+func (e *NetError) Timeout() bool {
+	return e.IsTimeout
+}
+
+// Temporary は一時的なエラーかを返します.
+func (e *NetError) Temporary() bool {
+	return e.IsTimeout
+}
+
+// Is はエラーの種類が target に対応するかを返します.
+func (e *NetError) Is(target error) bool {
+	switch e.Type {
+	case NetErrorTimeout:
+		return target == os.ErrDeadlineExceeded || target == context.DeadlineExceeded
+	case NetErrorRefused:
+		return target == errConnRefused
+	case NetErrorReset:
+		return target == errConnReset
+	case NetErrorEOF:
+		return target == io.ErrUnexpectedEOF
+	}
+	return false
+}
+
+// As は名前解決のエラーを *net.DNSError として返します.
+func (e *NetError) As(target any) bool {
+	if p, ok := target.(**net.DNSError); ok && e.Type == NetErrorDNS {
+		*p = &net.DNSError{
+			Err:        e.Cause,
+			Name:       e.Name,
+			IsNotFound: e.IsNotFound,
+			IsTimeout:  e.IsTimeout,
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build !plan9
+
+package cache
+
+import (
+	"errors"
+	"syscall"
+)
+
+// 接続の拒否とリセットを表すエラーです.
+var (
+	errConnRefused error = syscall.ECONNREFUSED
+	errConnReset   error = syscall.ECONNRESET
+)
+
+// isConnError は err が接続のエラー target を含むかを返します.
+func isConnError(err, target error) bool {
+	return errors.Is(err, target)
+}
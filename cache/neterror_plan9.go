@@ -0,0 +1,26 @@
+//go:build plan9
+
+package cache
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// 接続の拒否とリセットを表すエラーです.
+//
+// plan9 には errno が無いため *net.OpError のメッセージで判別します.
+var (
+	errConnRefused = errors.New("connection refused")
+	errConnReset   = errors.New("connection reset")
+)
+
+// isConnError は err が接続のエラー target を含むかを返します.
+func isConnError(err, target error) bool {
+	if errors.Is(err, target) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Err != nil && strings.Contains(opErr.Err.Error(), target.Error())
+}
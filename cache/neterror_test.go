@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestNetError(t *testing.T) {
+	dns := &url.Error{Op: "Get", URL: "https://nx.example/", Err: &net.OpError{
+		Op: "dial", Net: "tcp",
+		Err: &net.DNSError{Err: "no such host", Name: "nx.example", IsNotFound: true},
+	}}
+	ne := NewNetError(dns)
+	if ne.Type != NetErrorDNS || ne.Error() != dns.Err.Error() {
+		t.Errorf("%s = %+v, want %s", "dns", ne, NetErrorDNS)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(ne, &dnsErr) || dnsErr.Name != "nx.example" || !dnsErr.IsNotFound {
+		t.Errorf("%s = %+v, want *net.DNSError", "As", dnsErr)
+	}
+
+	timeout := fmt.Errorf("read: %w", os.ErrDeadlineExceeded)
+	if ne = NewNetError(timeout); ne.Type != NetErrorTimeout || !ne.Timeout() || !errors.Is(ne, os.ErrDeadlineExceeded) {
+		t.Errorf("%s = %+v, want %s", "timeout", ne, NetErrorTimeout)
+	}
+	refused := &url.Error{Op: "Get", URL: "https://example.com/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errConnRefused}}
+	if ne = NewNetError(refused); ne == nil || ne.Type != NetErrorRefused || !errors.Is(ne, errConnRefused) {
+		t.Errorf("%s = %+v, want %s", "refused", ne, NetErrorRefused)
+	}
+	if ne = NewNetError(context.Canceled); ne != nil {
+		t.Errorf("%s = %+v, want nil", "canceled", ne)
+	}
+	unreach := &url.Error{Op: "Get", URL: "https://example.com/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}}
+	if ne = NewNetError(unreach); ne == nil || ne.Type != NetErrorOther || errors.Is(ne, context.DeadlineExceeded) {
+		t.Errorf("%s = %+v, want %s", "other", ne, NetErrorOther)
+	}
+}
+
+func TestNetErrorExcluded(t *testing.T) {
+	// 呼び出し元に起因するエラーは記録しないこと
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"nil", nil},
+		{"canceled", &url.Error{Op: "Get", URL: "https://example.com/", Err: context.Canceled}},
+		{"caller deadline", &url.Error{Op: "Get", URL: "https://example.com/", Err: context.DeadlineExceeded}},
+		{"redirect policy", &url.Error{Op: "Get", URL: "https://example.com/", Err: errors.New("redirect not allowed")}},
+		{"too many redirects", &url.Error{Op: "Get", URL: "https://example.com/", Err: errors.New("stopped after 10 redirects")}},
+		{"unsupported scheme", &url.Error{Op: "Get", URL: "ftp://example.com/", Err: errors.New(`unsupported protocol scheme "ftp"`)}},
+		{"other", errors.New("boom")},
+	}
+	for _, tt := range tests {
+		if ne := NewNetError(tt.err); ne != nil {
+			t.Errorf("%s = %+v, want nil", tt.name, ne)
+		}
+	}
+
+	// 実際の http.Client が返すエラーでも記録しないこと
+	hc := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return errors.New("redirect not allowed")
+	}}
+	ts := httptest.NewServer(http.RedirectHandler("/next", http.StatusFound))
+	defer ts.Close()
+	for _, u := range []string{ts.URL, "ftp://example.com/"} {
+		_, err := hc.Get(u)
+		if ne := NewNetError(err); err == nil || ne != nil {
+			t.Errorf("%s = %+v (%v), want nil", u, ne, err)
+		}
+	}
+}
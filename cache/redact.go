@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultPlaceholder は伏せた値の代わりに記録する既定の文字列です.
+const DefaultPlaceholder = "[REDACTED]"
+
+// DefaultRedactHeaders は既定で値を伏せるヘッダです.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Redaction はキャッシュファイルに書き込む前に機密情報を伏せる規則です.
+//
+// 伏せた値は Placeholder に置き換えて記録します.
+// キャッシュファイルの名前は伏せる前のリクエストから計算するため 伏せても同じリクエストで参照できます.
+type Redaction struct {
+	// Headers は値を伏せるヘッダ名です.
+	// リクエストヘッダ レスポンスヘッダ リダイレクトの経過のヘッダに適用します.
+	Headers []string
+	// Fields は値を伏せるフィールド名です.
+	// フォーム形式と JSON 形式のリクエストボディとレスポンスボディに適用します.
+	// JSON は入れ子になったオブジェクトのフィールドにも適用します.
+	Fields []string
+	// Patterns はリクエストボディとレスポンスボディで値を伏せる部分に一致する正規表現です.
+	// テキストとフォーム形式のボディに適用します.
+	Patterns []*regexp.Regexp
+	// Placeholder は伏せた値の代わりに記録する文字列です. 空の場合は DefaultPlaceholder を使用します.
+	Placeholder string
+}
+
+// SetRedaction はキャッシュファイルに書き込む前に機密情報を伏せる規則を設定します.
+//
+// 既定では DefaultRedactHeaders のヘッダを伏せます. nil を指定すると何も伏せません.
+// Content-Encoding で圧縮されたレスポンスボディや 画像などテキストでないボディには
+// Fields と Patterns を適用せず 読み込まずにそのまま保存します.
+func (c *Cache) SetRedaction(rd *Redaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rd == nil {
+		rd = &Redaction{}
+	}
+	c.redact = rd
+}
+
+// redaction は機密情報を伏せる規則を返します.
+func (c *Cache) redaction() *Redaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.redact == nil {
+		return &Redaction{Headers: DefaultRedactHeaders}
+	}
+	return c.redact
+}
+
+// placeholder は伏せた値の代わりに記録する文字列を返します.
+func (rd *Redaction) placeholder() string {
+	if rd.Placeholder == "" {
+		return DefaultPlaceholder
+	}
+	return rd.Placeholder
+}
+
+// redactsBody はボディに適用する規則があるかを返します.
+func (rd *Redaction) redactsBody() bool {
+	return len(rd.Fields) > 0 || len(rd.Patterns) > 0
+}
+
+// request は値を伏せたリクエスト情報の複製を返します.
+func (rd *Redaction) request(cq *cReq) *cReq {
+	c := *cq
+	c.Header = rd.header(cq.Header)
+	body := cq.body()
+	if body == nil || !rd.redactsBody() {
+		return &c
+	}
+	if nb := rd.body(cq.Header, body); !bytes.Equal(nb, body) {
+		c.Payload, c.Body, c.Redacted = nb, nil, true
+		if len(nb) > payloadSize {
+			c.Payload, c.Body = nb[:payloadSize], nb
+		}
+	}
+	return &c
+}
+
+// response は値を伏せたレスポンス情報の複製とボディを返します.
+func (rd *Redaction) response(cs *cRes, r io.Reader) (*cRes, io.Reader, error) {
+	c := *cs
+	c.Header = rd.header(cs.Header)
+	if len(cs.Redirects) > 0 {
+		c.Redirects = make([]cHop, len(cs.Redirects))
+		for i, hop := range cs.Redirects {
+			hop.Header = rd.header(hop.Header)
+			c.Redirects[i] = hop
+		}
+	}
+	// テキスト以外のボディは一致する部分を伏せると壊れるため読み込まずにそのまま保存する
+	if !rd.redactsBody() || !redactable(cs.Header) || !cs.Uncompressed && cs.Header.Get("Content-Encoding") != "" {
+		return &c, r, nil
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if nb := rd.body(cs.Header, b); !bytes.Equal(nb, b) {
+		b = nb
+		if c.ContentLength >= 0 {
+			c.ContentLength = int64(len(b))
+		}
+		if c.Header.Get("Content-Length") != "" {
+			c.Header.Set("Content-Length", strconv.Itoa(len(b)))
+		}
+	}
+	return &c, bytes.NewReader(b), nil
+}
+
+// header は値を伏せた h の複製を返します.
+func (rd *Redaction) header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	h = h.Clone()
+	for _, name := range rd.Headers {
+		vals := h[http.CanonicalHeaderKey(name)]
+		for i := range vals {
+			vals[i] = rd.placeholder()
+		}
+	}
+	return h
+}
+
+// redactable は Content-Type がボディの一部を伏せられる形式かを返します.
+//
+// テキストとフォーム形式が対象で 画像や zip などのバイナリは対象にしません.
+func redactable(h http.Header) bool {
+	mediatype, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediatype == "application/x-www-form-urlencoded" || IsText(h)
+}
+
+// body は Content-Type に応じてフィールドと正規表現に一致する部分を伏せたボディを返します.
+//
+// 伏せられない形式のボディはそのまま返します.
+func (rd *Redaction) body(h http.Header, b []byte) []byte {
+	if !redactable(h) {
+		return b
+	}
+	mediatype, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case len(rd.Fields) == 0:
+	case mediatype == "application/x-www-form-urlencoded":
+		b = rd.form(b)
+	case strings.HasSuffix(mediatype, "json"):
+		b = rd.json(b)
+	}
+	for _, re := range rd.Patterns {
+		b = re.ReplaceAllLiteral(b, []byte(rd.placeholder()))
+	}
+	return b
+}
+
+// form はフォーム形式のボディのフィールドを伏せます.
+func (rd *Redaction) form(b []byte) []byte {
+	vals, err := url.ParseQuery(string(b))
+	if err != nil {
+		return b
+	}
+	changed := false
+	for _, name := range rd.Fields {
+		for i := range vals[name] {
+			vals[name][i] = rd.placeholder()
+			changed = true
+		}
+	}
+	if !changed {
+		return b
+	}
+	return []byte(vals.Encode())
+}
+
+// json は JSON 形式のボディのフィールドを伏せます.
+func (rd *Redaction) json(b []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return b
+	}
+	if !rd.walk(v) {
+		return b
+	}
+	w := &bytes.Buffer{}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return b
+	}
+	return bytes.TrimSuffix(w.Bytes(), []byte("\n"))
+}
+
+// walk は JSON の値を辿ってフィールドを伏せ 伏せたものがあるかを返します.
+func (rd *Redaction) walk(v any) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if contains(rd.Fields, key) {
+				v[key] = rd.placeholder()
+				changed = true
+			} else if rd.walk(val) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, val := range v {
+			if rd.walk(val) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
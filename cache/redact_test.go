@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	c := NewMemory(3)
+	c.SetRedaction(&Redaction{
+		Headers:  []string{"Authorization", "Set-Cookie"},
+		Fields:   []string{"password", "token"},
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`\d{4}-\d{4}-\d{4}-\d{4}`)},
+	})
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/login", strings.NewReader("user=a&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Basic xxx")
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := cf.Name()
+	body := `{"user":{"name":"a","token":"abc"},"card":"1234-5678-9012-3456"}`
+	err = cf.Store(&http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"sid=1", "uid=2"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 伏せても同じリクエストで参照できること
+	if cf, err = tx.NewFile(req); err != nil || cf.Name() != name || !cf.IsExists() {
+		t.Fatalf("%s = %v, %v, want %s", "NewFile", cf.Name(), err, name)
+	}
+	resp, err := cf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	want := `{"card":"[REDACTED]","user":{"name":"a","token":"[REDACTED]"}}`
+	if string(b) != want || resp.ContentLength != int64(len(want)) {
+		t.Errorf("%s = %s (%d), want %s", "body", b, resp.ContentLength, want)
+	}
+	if got := resp.Header["Set-Cookie"]; len(got) != 2 || got[0] != DefaultPlaceholder {
+		t.Errorf("%s = %v, want %s", "Set-Cookie", got, DefaultPlaceholder)
+	}
+	if got := resp.Request.Header.Get("Authorization"); got != DefaultPlaceholder {
+		t.Errorf("%s = %q, want %q", "Authorization", got, DefaultPlaceholder)
+	}
+	b, _ = io.ReadAll(resp.Request.Body)
+	if want := "password=%5BREDACTED%5D&user=a"; string(b) != want {
+		t.Errorf("%s = %s, want %s", "payload", b, want)
+	}
+
+	// バイナリのボディは正規表現に一致する部分があっても伏せないこと
+	img, _ := http.NewRequest(http.MethodGet, "https://example.com/card.png", nil)
+	png := "\x89PNG\x00 1234-5678-9012-3456 \xff"
+	if cf, err = tx.NewFile(img); err != nil {
+		t.Fatal(err)
+	}
+	err = cf.Store(&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"image/png"}},
+		Body:       io.NopCloser(strings.NewReader(png)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loadBody(t, tx, img); got != png {
+		t.Errorf("%s = %q, want %q", "binary body", got, png)
+	}
+
+	// 既定では認証情報と Cookie を伏せること
+	def := NewMemory(3)
+	dtx, err := def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creq, _ := http.NewRequest(http.MethodGet, "https://example.com/me", nil)
+	creq.Header.Set("Cookie", "sid=1")
+	if cf, err = dtx.NewFile(creq); err != nil {
+		t.Fatal(err)
+	}
+	err = cf.Store(&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Set-Cookie": {"sid=2"}},
+		Body:       io.NopCloser(strings.NewReader("me")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = cf.Load(); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Request.Header.Get("Cookie") != DefaultPlaceholder || resp.Header.Get("Set-Cookie") != DefaultPlaceholder {
+		t.Errorf("%s = %v, %v, want redacted", "default redaction", resp.Request.Header, resp.Header)
+	}
+	dtx.Release()
+
+	// 伏せたキャッシュファイルは整合性の検査で問題にならないこと
+	tx.Release()
+	if probs, err := c.Verify(FixNone); err != nil || len(probs) != 0 {
+		t.Errorf("%s = %v, %v, want none", "Verify", probs, err)
+	}
+}
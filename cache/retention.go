@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// Verdict は保持ルールによるトランザクションの判定を表します.
+type Verdict int
+
+const (
+	Undecided Verdict = iota // 判定しない
+	Discard                  // 破棄する
+	Keep                     // 保持する
+)
+
+// Rule はトランザクションの保持ルールです.
+//
+// txs には固定されていないトランザクションが新しい順に渡され
+// それぞれのトランザクションに対する判定を返します.
+//
+// 複数のルールを組み合わせた場合 いずれかのルールが Keep と判定したトランザクションは保持され
+// それ以外で Discard と判定されたトランザクションが破棄されます.
+// 固定されたトランザクションと 保持するトランザクションのフォーク元はルールに関係なく保持されます.
+type Rule func(txs []*Tx, now time.Time) ([]Verdict, error)
+
+// KeepGenerations は新しい n 世代を超えたトランザクションを破棄します.
+func KeepGenerations(n int) Rule {
+	return func(txs []*Tx, now time.Time) ([]Verdict, error) {
+		vs := make([]Verdict, len(txs))
+		for i := n; i < len(txs); i++ {
+			if i >= 0 {
+				vs[i] = Discard
+			}
+		}
+		return vs, nil
+	}
+}
+
+// KeepAge は作成から d を経過したトランザクションを破棄します.
+func KeepAge(d time.Duration) Rule {
+	return func(txs []*Tx, now time.Time) ([]Verdict, error) {
+		vs := make([]Verdict, len(txs))
+		for i, tx := range txs {
+			if now.Sub(tx.Created()) > d {
+				vs[i] = Discard
+			}
+		}
+		return vs, nil
+	}
+}
+
+// KeepSize は新しい順に合計サイズが max バイトを超えたトランザクションを破棄します.
+func KeepSize(max int64) Rule {
+	return func(txs []*Tx, now time.Time) ([]Verdict, error) {
+		var total int64
+		vs := make([]Verdict, len(txs))
+		for i, tx := range txs {
+			size, err := tx.Size()
+			if err != nil {
+				return nil, err
+			}
+			if total += size; total > max {
+				vs[i] = Discard
+			}
+		}
+		return vs, nil
+	}
+}
+
+// KeepDaily は直近 n 日について 日毎に最も新しいコミットされたトランザクションを保持します.
+func KeepDaily(n int) Rule {
+	return keepPer(n, func(t time.Time) time.Time {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	})
+}
+
+// KeepWeekly は直近 n 週について 週毎に最も新しいコミットされたトランザクションを保持します.
+// 週は月曜日から始まります.
+func KeepWeekly(n int) Rule {
+	return keepPer(n, func(t time.Time) time.Time {
+		y, m, d := t.Date()
+		wd := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-wd, 0, 0, 0, 0, t.Location())
+	})
+}
+
+// keepPer は直近 n 期間について 期間毎に最も新しいコミットされたトランザクションを保持します.
+// period は時刻をその期間の開始時刻に切り捨てます.
+//
+// 途中で中断したトランザクションを残しても再現に使えないため
+// コミットされていないトランザクションは対象にしません.
+func keepPer(n int, period func(time.Time) time.Time) Rule {
+	return func(txs []*Tx, now time.Time) ([]Verdict, error) {
+		vs := make([]Verdict, len(txs))
+		var last time.Time
+		for i, rest := 0, n; i < len(txs) && rest > 0; i++ {
+			if !Committed(txs[i]) {
+				continue
+			}
+			if p := period(txs[i].Created()); !p.Equal(last) {
+				vs[i] = Keep
+				last = p
+				rest--
+			}
+		}
+		return vs, nil
+	}
+}
+
+// SetRetention はトランザクションの保持ルールを設定します.
+//
+// 既定では Cache の作成時に指定した世代数の KeepGenerations が設定されています.
+// 設定したルールは次にトランザクションを作成したときや Discard を呼び出したときに適用されます.
+// 作成時の破棄から適用するには NewWithRetention を使用します.
+func (c *Cache) SetRetention(rules ...Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = rules
+}
+
+// Discard は保持ルールに従って古いトランザクションを破棄し 破棄したトランザクションを返します.
+//
+// dryRun が true の場合は破棄せずに 破棄する予定のトランザクションを返します.
+// 使用中のトランザクションは破棄されないため dryRun で返されたものが全て破棄されるとは限りません.
+// 途中で破棄に失敗した場合は それまでに破棄したトランザクションをエラーと共に返します.
+func (c *Cache) Discard(dryRun bool) ([]*Tx, error) {
+	var discarded []*Tx
+	fn := c.update
+	if dryRun {
+		fn = c.view
+	}
+	err := fn(func() error {
+		var err error
+		if dryRun {
+			discarded, err = c.plan(time.Now())
+		} else {
+			discarded, err = c.discard()
+		}
+		return err
+	})
+	return discarded, err
+}
+
+// plan は保持ルールに従って破棄するトランザクションを返します.
+func (c *Cache) plan(now time.Time) ([]*Tx, error) {
+	rules := c.rules
+	if rules == nil {
+		rules = []Rule{KeepGenerations(c.numTx)}
+	}
+
+	var txs []*Tx
+	for _, tx := range c.Trans {
+		if !tx.Pinned {
+			txs = append(txs, tx)
+		}
+	}
+
+	verdicts := make([]Verdict, len(txs))
+	for _, rule := range rules {
+		vs, err := rule(txs, now)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range vs {
+			if v > verdicts[i] {
+				verdicts[i] = v
+			}
+		}
+	}
+
+	var discards []*Tx
+	for i, v := range verdicts {
+		if v == Discard {
+			discards = append(discards, txs[i])
+		}
+	}
+
+	// 残すトランザクションのフォーク元は破棄しない
+	for changed := true; changed; {
+		changed = false
+		for _, tx := range c.Trans {
+			if tx.base != nil && !containsTx(discards, tx) && containsTx(discards, tx.base) {
+				discards = removeTx(discards, tx.base)
+				changed = true
+			}
+		}
+	}
+	return discards, nil
+}
+
+// discard は保持ルールに従って古いトランザクションを破棄し 破棄したトランザクションを返します.
+//
+// 使用中のトランザクションは破棄せずに残します.
+// 途中で削除に失敗した場合は 削除済みのトランザクションが管理ファイルに残らないよう
+// そこまでの結果を保存してからエラーを返します.
+func (c *Cache) discard() ([]*Tx, error) {
+	discards, err := c.plan(time.Now())
+	if err != nil || len(discards) == 0 {
+		return nil, err
+	}
+
+	var discarded []*Tx
+	for _, tx := range discards {
+		var deleted bool
+		if deleted, err = c.deleteTx(tx); err != nil {
+			break
+		}
+		if deleted {
+			discarded = append(discarded, tx)
+		}
+	}
+
+	trans := make([]*Tx, 0, len(c.Trans))
+	for _, tx := range c.Trans {
+		if !containsTx(discarded, tx) {
+			trans = append(trans, tx)
+		}
+	}
+	c.Trans = trans
+	if err != nil && len(discarded) > 0 {
+		if serr := c.saveCtlFile(); serr != nil {
+			err = errors.Join(err, serr)
+		}
+	}
+	return discarded, err
+}
+
+// removeTx は txs から tx を取り除きます.
+func removeTx(txs []*Tx, tx *Tx) []*Tx {
+	for i, t := range txs {
+		if t == tx {
+			return append(txs[:i], txs[i+1:]...)
+		}
+	}
+	return txs
+}
+
+// containsTx は txs に tx が含まれているかを返します.
+func containsTx(txs []*Tx, tx *Tx) bool {
+	for _, t := range txs {
+		if t == tx {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	c := NewMemory(10)
+	now := time.Now()
+	ages := []time.Duration{0, time.Hour, 24 * time.Hour, 48 * time.Hour, 9 * 24 * time.Hour}
+	for i := len(ages) - 1; i >= 0; i-- {
+		tx, err := c.NewTransaction(WithName(string(rune('a' + i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		tx.Release()
+	}
+	c.update(func() error {
+		for i, age := range ages {
+			c.Trans[i].CreateAt = now.Add(-age).Format(createAtLayout)
+		}
+		return nil
+	})
+	names := func(txs []*Tx) []string {
+		var names []string
+		for _, tx := range txs {
+			names = append(names, tx.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  []string
+	}{
+		{"generations", []Rule{KeepGenerations(3)}, []string{"d", "e"}},
+		{"age", []Rule{KeepAge(36 * time.Hour)}, []string{"d", "e"}},
+		{"age+daily", []Rule{KeepAge(36 * time.Hour), KeepDaily(3)}, []string{"e"}},
+	}
+	for _, tt := range tests {
+		c.SetRetention(tt.rules...)
+		got, err := c.Discard(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names(got), tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, names(got), tt.want)
+		}
+	}
+
+	// 固定されたトランザクションは破棄されないこと
+	if err := c.Pin("e"); err != nil {
+		t.Fatal(err)
+	}
+	c.SetRetention(KeepAge(36 * time.Hour))
+	got, err := c.Discard(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"d"}; !reflect.DeepEqual(names(got), want) {
+		t.Errorf("%s = %v, want %v", "discard", names(got), want)
+	}
+	if want := []string{"a", "b", "c", "e"}; !reflect.DeepEqual(names(c.Trans), want) {
+		t.Errorf("%s = %v, want %v", "transactions", names(c.Trans), want)
+	}
+}
+
+func TestKeepSize(t *testing.T) {
+	c := NewMemory(10)
+	for _, name := range []string{"c", "b", "a"} {
+		tx, err := c.NewTransaction(WithName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.st.Put(tx.Name, "x", strings.NewReader(strings.Repeat("x", 100)))
+		tx.Release()
+	}
+	c.SetRetention(KeepSize(250))
+	got, err := c.Discard(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "c" {
+		t.Errorf("%s = %v, want %v", "discard", got, "[c]")
+	}
+}
+
+func TestKeepPer(t *testing.T) {
+	// 2023-07-05 は水曜日
+	now := time.Date(2023, 7, 5, 12, 0, 0, 0, time.Local)
+	var txs []*Tx
+	for _, d := range []int{0, 0, 1, 2, 3, 7, 8} {
+		txs = append(txs, &Tx{
+			CreateAt: now.AddDate(0, 0, -d).Format(createAtLayout),
+			Status:   StatusCommitted,
+		})
+	}
+	txs[3].Status = StatusAborted
+	tests := []struct {
+		name string
+		rule Rule
+		want []Verdict
+	}{
+		{"daily", KeepDaily(3), []Verdict{Keep, Undecided, Keep, Undecided, Keep, Undecided, Undecided}},
+		{"weekly", KeepWeekly(2), []Verdict{Keep, Undecided, Undecided, Undecided, Keep, Undecided, Undecided}},
+	}
+	for _, tt := range tests {
+		got, err := tt.rule(txs, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// failDeleteStorage は指定したトランザクションの削除に失敗する Storage です.
+type failDeleteStorage struct {
+	*MemStorage
+	fail string
+}
+
+func (s *failDeleteStorage) DeleteTx(tx string) error {
+	if tx == s.fail {
+		return errors.New("delete failed")
+	}
+	return s.MemStorage.DeleteTx(tx)
+}
+
+func TestNewWithRetention(t *testing.T) {
+	st := &failDeleteStorage{MemStorage: NewMemStorage()}
+	c, err := NewWithStorage(st, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		tx, err := c.NewTransaction(WithName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Release()
+	}
+	names := func(c *Cache) []string {
+		var names []string
+		for _, tx := range c.Trans {
+			names = append(names, tx.Name)
+		}
+		return names
+	}
+
+	// ルールを指定しない場合は作成時に破棄しないこと
+	if c, err = NewWithRetention(st); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"d", "c", "b", "a"}; !reflect.DeepEqual(names(c), want) {
+		t.Errorf("%s = %v, want %v", "no rules", names(c), want)
+	}
+
+	// 途中で削除に失敗しても 削除済みのトランザクションを管理ファイルから取り除くこと
+	st.fail = "b"
+	if _, err = NewWithRetention(st, KeepGenerations(1)); err == nil {
+		t.Fatalf("%s = %v, want error", "discard", err)
+	}
+	if c, err = OpenReadOnly(st); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"d", "b", "a"}; !reflect.DeepEqual(names(c), want) {
+		t.Errorf("%s = %v, want %v", "partial discard", names(c), want)
+	}
+
+	// 作成時から指定したルールが適用されること
+	st.fail = ""
+	if c, err = NewWithRetention(st, KeepGenerations(2)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"d", "b"}; !reflect.DeepEqual(names(c), want) {
+		t.Errorf("%s = %v, want %v", "generations", names(c), want)
+	}
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Storage はキャッシュの保存先を表すインターフェイスです.
+//
+// Storage はトランザクション名とエントリ名の 2 階層でキャッシュファイルを管理し
+// これとは別にトランザクションの管理情報 (cache.json) を 1 つ保持します.
+// 存在しないエントリや管理情報を参照した場合 fs.ErrNotExist をラップしたエラーを返します.
+type Storage interface {
+	// GetMeta は管理情報を読み込みます.
+	GetMeta() ([]byte, error)
+	// PutMeta は管理情報を保存します.
+	PutMeta(b []byte) error
+
+	// Get はエントリを読み込みます.
+	Get(tx, name string) (io.ReadCloser, error)
+	// Put はエントリを保存します.
+	// r を最後まで読み込めなかった場合 エントリは保存されません.
+	Put(tx, name string, r io.Reader) error
+	// Stat はエントリの情報を返します.
+	Stat(tx, name string) (Info, error)
+	// List はトランザクションに含まれるエントリの一覧を返します.
+	List(tx string) ([]Info, error)
+	// Delete はエントリを削除します.
+	Delete(tx, name string) error
+
+	// ListTx は保存先に存在するトランザクション名の一覧を返します.
+	ListTx() ([]string, error)
+	// DeleteTx はトランザクションを全てのエントリと共に削除します.
+	DeleteTx(tx string) error
+}
+
+// Locker はプロセス間の排他制御を提供する Storage です.
+//
+// 保存先が Locker を実装している場合 Cache は管理情報を更新する間 Lock を保持し
+// 使用中のトランザクションに LockTx を保持します.
+// 古いトランザクションを破棄する際は TryLockTx で使用中でないことを確認します.
+//
+// DirStorage は unix では flock(2) を windows では LockFileEx を使用します.
+// どちらも使用できない環境ではロックファイルの作成で代用するため 共有ロックは排他されず
+// 異常終了した時のロックファイルは手作業で削除する必要があります.
+type Locker interface {
+	// Lock は管理情報の排他ロックを取得します.
+	Lock() (unlock func() error, err error)
+	// LockTx はトランザクションの共有ロックを取得します.
+	LockTx(tx string) (unlock func() error, err error)
+	// TryLockTx はトランザクションの排他ロックを待たずに取得します.
+	// 他で使用中の場合 ok に false を返します.
+	TryLockTx(tx string) (unlock func() error, ok bool, err error)
+}
+
+// Appender はエントリへの追記を提供する Storage です.
+//
+// 保存先が Appender を実装している場合 マニフェストは変更を追記するログとして保存され
+// エントリを保存する度にマニフェスト全体を書き直さずに済みます.
+// 実装していない場合は管理情報の排他ロックを取得して マニフェストを読み込み直してから書き直します.
+type Appender interface {
+	// Append はエントリの末尾に b を追記します. エントリがなければ作成します.
+	// 複数のプロセスから同時に追記しても 1 回の追記の内容が混ざってはいけません.
+	Append(tx, name string, b []byte) error
+}
+
+// Info はエントリの情報を表します.
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// isNotExist は err が存在しないことを表すエラーかを返します.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// notExist はエントリが存在しないことを表すエラーを返します.
+func notExist(op, tx, name string) error {
+	return &fs.PathError{Op: op, Path: tx + "/" + name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testStorage(t *testing.T, st Storage) {
+	if _, err := st.GetMeta(); !isNotExist(err) {
+		t.Errorf("%s = %v, want %v", "GetMeta", err, "not exist")
+	}
+	if err := st.PutMeta([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := st.GetMeta(); err != nil || string(b) != `{}` {
+		t.Errorf("%s = %q, %v, want %q", "GetMeta", b, err, `{}`)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := st.Put("tx1", name, strings.NewReader("body "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := st.Get("tx1", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "body b" {
+		t.Errorf("%s = %q, want %q", "Get", b, "body b")
+	}
+	if _, err = st.Get("tx1", "c"); !isNotExist(err) {
+		t.Errorf("%s = %v, want %v", "Get", err, "not exist")
+	}
+
+	infos, err := st.List("tx1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, info.Name)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", "List", got, want)
+	}
+
+	if err = st.Delete("tx1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.Stat("tx1", "a"); !isNotExist(err) {
+		t.Errorf("%s = %v, want %v", "Stat", err, "not exist")
+	}
+	if info, err := st.Stat("tx1", "b"); err != nil || info.Size != 6 {
+		t.Errorf("%s = %v, %v, want size %d", "Stat", info, err, 6)
+	}
+
+	if txs, err := st.ListTx(); err != nil || !reflect.DeepEqual(txs, []string{"tx1"}) {
+		t.Errorf("%s = %v, %v, want %v", "ListTx", txs, err, []string{"tx1"})
+	}
+	if err = st.DeleteTx("tx1"); err != nil {
+		t.Fatal(err)
+	}
+	if txs, err := st.ListTx(); err != nil || len(txs) != 0 {
+		t.Errorf("%s = %v, %v, want %v", "ListTx", txs, err, []string{})
+	}
+}
+
+func TestDirStorage(t *testing.T) {
+	testStorage(t, NewDirStorage(t.TempDir()))
+}
+
+func TestMemStorage(t *testing.T) {
+	testStorage(t, NewMemStorage())
+}
+
+func TestCacheStorage(t *testing.T) {
+	st := NewMemStorage()
+	c, err := NewWithStorage(st, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.IsExists() {
+		t.Fatalf("%s = %v, want %v", "IsExists", true, false)
+	}
+	err = cf.Store(&http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader("hello\nworld")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 管理情報を読み直しても同じ内容が得られること
+	c, err = NewWithStorage(st, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = c.GetTransaction(tx.Name); err != nil {
+		t.Fatal(err)
+	}
+	if cf, err = tx.NewFile(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "hello\nworld" {
+		t.Errorf("%s = %q, want %q", "body", b, "hello\nworld")
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("%s = %q, want %q", "Content-Type", got, "text/plain")
+	}
+	if got := resp.Request.URL.String(); got != "https://example.com/" {
+		t.Errorf("%s = %q, want %q", "Request.URL", got, "https://example.com/")
+	}
+}
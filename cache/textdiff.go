@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// editOp は行単位の編集操作を表します.
+type editOp int
+
+const (
+	opEqual editOp = iota
+	opDelete
+	opInsert
+)
+
+// edit は行単位の編集を表します.
+type edit struct {
+	op   editOp
+	a, b int // a, b の行番号 (0 始まり)
+}
+
+// unifiedDiff は a から b への差分を unified 形式で返します.
+//
+// 差分がない場合は空文字列を返します.
+// context は変更箇所の前後に出力する行数です.
+func unifiedDiff(aname, bname, a, b string, context int) string {
+	al, bl := splitLines(a), splitLines(b)
+	edits := diffLines(al, bl)
+
+	var sb strings.Builder
+	for i := 0; i < len(edits); {
+		// 変更箇所を探す
+		for i < len(edits) && edits[i].op == opEqual {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		// 前後の文脈を含めてハンクの範囲を決める
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(edits) {
+			if edits[end].op != opEqual {
+				end++
+				continue
+			}
+			j := end
+			for j < len(edits) && edits[j].op == opEqual {
+				j++
+			}
+			if j == len(edits) || j-end > 2*context {
+				end += context
+				if end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = j
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aname, bname)
+		}
+		writeHunk(&sb, edits[start:end], al, bl)
+		i = end
+	}
+	return sb.String()
+}
+
+// writeHunk はハンクを出力します.
+func writeHunk(sb *strings.Builder, edits []edit, al, bl []string) {
+	var astart, bstart, acount, bcount int
+	astart, bstart = -1, -1
+	for _, e := range edits {
+		if e.op != opInsert {
+			if astart < 0 {
+				astart = e.a
+			}
+			acount++
+		}
+		if e.op != opDelete {
+			if bstart < 0 {
+				bstart = e.b
+			}
+			bcount++
+		}
+	}
+	if astart < 0 {
+		astart = edits[0].a - 1
+	}
+	if bstart < 0 {
+		bstart = edits[0].b - 1
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", astart+1, acount, bstart+1, bcount)
+	for _, e := range edits {
+		switch e.op {
+		case opEqual:
+			sb.WriteString(" " + al[e.a])
+		case opDelete:
+			sb.WriteString("-" + al[e.a])
+		case opInsert:
+			sb.WriteString("+" + bl[e.b])
+		}
+	}
+}
+
+// splitLines は s を改行を含む行に分割します.
+// 最後の行に改行がない場合は改行を補います.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
+
+// diffLines は Myers のアルゴリズムで a から b への最短の編集を求めます.
+//
+// 中央のスネークで分割統治する線形空間の方法を使うため
+// 大きく書き換えられたボディでも使用するメモリは行数に比例します.
+func diffLines(a, b []string) []edit {
+	var edits []edit
+	diffRange(a, b, 0, len(a), 0, len(b), &edits)
+	return edits
+}
+
+// diffRange は a[a0:a1] から b[b0:b1] への編集を edits に追加します.
+func diffRange(a, b []string, a0, a1, b0, b1 int, edits *[]edit) {
+	for a0 < a1 && b0 < b1 && a[a0] == b[b0] {
+		*edits = append(*edits, edit{opEqual, a0, b0})
+		a0++
+		b0++
+	}
+	// 共通の末尾は最後に出力する
+	aend := a1
+	for a0 < a1 && b0 < b1 && a[a1-1] == b[b1-1] {
+		a1--
+		b1--
+	}
+
+	switch {
+	case a0 == a1:
+		for y := b0; y < b1; y++ {
+			*edits = append(*edits, edit{opInsert, a0, y})
+		}
+	case b0 == b1:
+		for x := a0; x < a1; x++ {
+			*edits = append(*edits, edit{opDelete, x, b0})
+		}
+	default:
+		if x, y, ok := middleSnake(a[a0:a1], b[b0:b1]); ok {
+			diffRange(a, b, a0, a0+x, b0, b0+y, edits)
+			diffRange(a, b, a0+x, a1, b0+y, b1, edits)
+		} else {
+			for x := a0; x < a1; x++ {
+				*edits = append(*edits, edit{opDelete, x, b0})
+			}
+			for y := b0; y < b1; y++ {
+				*edits = append(*edits, edit{opInsert, a1, y})
+			}
+		}
+	}
+
+	for ; a1 < aend; a1, b1 = a1+1, b1+1 {
+		*edits = append(*edits, edit{opEqual, a1, b1})
+	}
+}
+
+// middleSnake は a から b への最短の編集経路を前後から同時に探索し
+// 経路が出会う点 (x, y) を返します.
+//
+// 共通する行が全くない場合は ok に false を返します.
+func middleSnake(a, b []string) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	vf := make([]int, 2*maxD+2)
+	vb := make([]int, 2*maxD+2)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[offset+1], vb[offset+1] = 0, 0
+	delta := n - m
+	front := delta%2 != 0
+
+	// 範囲の外に出た対角線は以降の探索から外す
+	var kfStart, kfEnd, kbStart, kbEnd int
+	for d := 0; d < maxD; d++ {
+		for k := -d + kfStart; k <= d-kfEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || (k != d && vf[i-1] < vf[i+1]) {
+				x = vf[i+1]
+			} else {
+				x = vf[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[i] = x
+			if x > n {
+				kfEnd += 2
+			} else if y > m {
+				kfStart += 2
+			} else if front {
+				if j := offset + delta - k; j >= 0 && j < len(vb) && vb[j] != -1 && x >= n-vb[j] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + kbStart; k <= d-kbEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || (k != d && vb[i-1] < vb[i+1]) {
+				x = vb[i+1]
+			} else {
+				x = vb[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[i] = x
+			if x > n {
+				kbEnd += 2
+			} else if y > m {
+				kbStart += 2
+			} else if !front {
+				if j := offset + delta - k; j >= 0 && j < len(vf) && vf[j] != -1 {
+					fx := vf[j]
+					if fx >= n-x {
+						return fx, offset + fx - j, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
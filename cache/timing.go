@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Timing はリクエストの所要時間と接続の情報を表します.
+type Timing struct {
+	Wait       time.Duration `json:"wait"`                  // アクセス間隔を空けるために待った時間
+	DNS        time.Duration `json:"dns,omitempty"`         // 名前解決に掛かった時間
+	Connect    time.Duration `json:"connect,omitempty"`     // TCP 接続に掛かった時間
+	TLS        time.Duration `json:"tls,omitempty"`         // TLS ハンドシェイクに掛かった時間
+	FirstByte  time.Duration `json:"first_byte,omitempty"`  // リクエストの開始からレスポンスの最初のバイトまでの時間
+	Total      time.Duration `json:"total"`                 // リクエストの開始からボディを読み終えるまでの時間
+	Reused     bool          `json:"reused,omitempty"`      // 接続を再利用した
+	RemoteAddr string        `json:"remote_addr,omitempty"` // 接続先のアドレス
+}
+
+// timingKey は Load が返すレスポンスのリクエストに Timing を格納するキーです.
+type timingKey struct{}
+
+// ResponseTiming は Load が返したレスポンスに記録されている Timing を返します.
+//
+// 記録されていない場合は nil を返します.
+func ResponseTiming(resp *http.Response) *Timing {
+	if resp == nil || resp.Request == nil {
+		return nil
+	}
+	t, _ := resp.Request.Context().Value(timingKey{}).(*Timing)
+	return t
+}
+
+// withTiming は t を格納したリクエストの複製を返します.
+func withTiming(req *http.Request, t *Timing) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), timingKey{}, t))
+}
+
+// StoreWithTiming はキャッシュファイルに http.Response の内容を保存し
+// 所要時間をキャッシュファイルとマニフェストに記録します.
+//
+// マニフェストには t のボディを読み終えた時点の内容を記録するため
+// ボディの読み込みに合わせて Total を更新することができます.
+// キャッシュファイルにはボディより前に書き込む時点の内容を記録するため
+// マニフェストを作り直した場合 Total は失われます.
+func (f *File) StoreWithTiming(resp *http.Response, t *Timing) error {
+	return f.storeCres(newCres(resp), resp.Body, time.Now(), t)
+}
@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// quarantineTx は隔離したエントリを保存するトランザクション名です.
+//
+// トランザクション名に使用できない名前にして 通常のトランザクションと衝突しないようにします.
+const quarantineTx = ".quarantine"
+
+// 検査で見つかる問題の種類です.
+const (
+	ProblemUnreadable     = "unreadable"      // キャッシュファイルを読み込めない
+	ProblemNameMismatch   = "name-mismatch"   // ファイル名がリクエストの識別子と一致しない
+	ProblemLengthMismatch = "length-mismatch" // ボディの長さが ContentLength と一致しない
+	ProblemManifest       = "manifest"        // マニフェストがキャッシュファイルと一致しない
+	ProblemOrphan         = "orphan"          // 管理ファイルに登録されていないトランザクション
+	ProblemMissingBase    = "missing-base"    // フォーク元のトランザクションがない
+)
+
+// Fix は検査で見つかった問題への対処方法です.
+type Fix int
+
+const (
+	FixNone       Fix = iota // 報告するだけで変更しない
+	FixRepair                // 修復できない問題は削除する
+	FixQuarantine            // 問題のあるファイルを隔離する
+)
+
+// Problem は検査で見つかった問題を表します.
+type Problem struct {
+	Tx     string // トランザクション名
+	Name   string // エントリ名 トランザクション自体の問題では空
+	Kind   string // 問題の種類
+	Detail string // 問題の詳細
+	Action string // 対処した内容 対処していなければ空
+}
+
+// String は問題を 1 行の文字列で返します.
+func (p Problem) String() string {
+	s := p.Tx
+	if p.Name != "" {
+		s += "/" + p.Name
+	}
+	s += ": " + p.Kind
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	if p.Action != "" {
+		s += " (" + p.Action + ")"
+	}
+	return s
+}
+
+// Verify はキャッシュの整合性を検査し 見つかった問題を返します.
+//
+// 各キャッシュファイルが読み込めること ファイル名がリクエストの識別子と一致すること (ボディの一部を伏せたものを除く)
+// ボディの長さが ContentLength と一致すること マニフェストと一致すること
+// 保存先の全てのトランザクションが管理ファイルに登録されていることを検査します.
+//
+// fix に FixRepair を指定すると 登録されていないトランザクションを管理ファイルに登録し
+// ファイル名の誤ったキャッシュファイルを正しい名前に変え 修復できないキャッシュファイルを削除します.
+// FixQuarantine を指定すると 問題のあるキャッシュファイルや登録されていないトランザクションを
+// 隔離用の領域に移動します. いずれもマニフェストは作り直します.
+//
+// 他で使用中のトランザクションは検査だけして変更しません.
+// FixNone では管理ファイルも保存しないため 読み取り専用の Cache でも検査できます.
+func (c *Cache) Verify(fix Fix) ([]Problem, error) {
+	run := c.update
+	if fix == FixNone {
+		run = c.view
+	}
+	var probs []Problem
+	err := run(func() error {
+		names, err := c.st.ListTx()
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			tx := c.findTx(name)
+			if tx == nil {
+				p := Problem{Tx: name, Kind: ProblemOrphan, Detail: "not in " + ctlname}
+				tx = newTx(c, name)
+				switch fix {
+				case FixRepair:
+					tx.CreateAt = c.orphanCreateAt(name)
+					c.Trans = append(c.Trans, tx)
+					sort.SliceStable(c.Trans, func(i, j int) bool {
+						return c.Trans[i].CreateAt > c.Trans[j].CreateAt
+					})
+					p.Action = "registered"
+				case FixQuarantine:
+					if p.Action, err = c.quarantineTx(name); err != nil {
+						return err
+					}
+				}
+				probs = append(probs, p)
+				if fix == FixQuarantine {
+					continue
+				}
+			}
+			txprobs, err := c.verifyTx(tx, fix)
+			if err != nil {
+				return err
+			}
+			probs = append(probs, txprobs...)
+		}
+		for _, tx := range c.Trans {
+			if tx.Base != "" && tx.base == nil {
+				probs = append(probs, Problem{Tx: tx.Name, Kind: ProblemMissingBase, Detail: tx.Base})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return probs, nil
+}
+
+// orphanCreateAt は登録されていないトランザクションの作成日時を
+// 最も古いエントリの更新日時から推定します.
+func (c *Cache) orphanCreateAt(name string) string {
+	infos, _ := c.st.List(name)
+	t := time.Now()
+	for _, info := range infos {
+		if info.ModTime.Before(t) {
+			t = info.ModTime
+		}
+	}
+	return t.Format(createAtLayout)
+}
+
+// quarantineTx は登録されていないトランザクションの全てのエントリを隔離します.
+func (c *Cache) quarantineTx(name string) (string, error) {
+	unlock, ok, err := c.tryLockTx(name)
+	if err != nil || !ok {
+		return "", err
+	}
+	defer unlock()
+
+	infos, err := c.st.List(name)
+	if err != nil && !isNotExist(err) {
+		return "", err
+	}
+	for _, info := range infos {
+		if err = c.moveEntry(name, info.Name, quarantineTx, name+"-"+info.Name); err != nil {
+			return "", err
+		}
+	}
+	if err = c.st.DeleteTx(name); err != nil {
+		return "", err
+	}
+	return "quarantined", nil
+}
+
+// tryLockTx はトランザクションを変更するため排他ロックを待たずに取得します.
+//
+// 他で使用中の場合 ok に false を返します.
+func (c *Cache) tryLockTx(name string) (unlock func() error, ok bool, err error) {
+	l, isLocker := c.st.(Locker)
+	if !isLocker {
+		return func() error { return nil }, true, nil
+	}
+	return l.TryLockTx(name)
+}
+
+// moveEntry はエントリを移動します.
+func (c *Cache) moveEntry(tx, name, newtx, newname string) error {
+	r, err := c.st.Get(tx, name)
+	if err != nil {
+		return err
+	}
+	err = c.st.Put(newtx, newname, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	return c.st.Delete(tx, name)
+}
+
+// verifyTx はトランザクションのキャッシュファイルとマニフェストを検査します.
+func (c *Cache) verifyTx(tx *Tx, fix Fix) ([]Problem, error) {
+	infos, err := c.st.List(tx.Name)
+	if isNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// 作り直されないようにマニフェストを直接読み込む
+	var probs []Problem
+	m, err := tx.readManifest(false)
+	if err != nil {
+		probs = append(probs, Problem{Tx: tx.Name, Name: manifestName, Kind: ProblemManifest, Detail: err.Error()})
+	}
+
+	var broken []int
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		if !isEntryName(info.Name) {
+			continue
+		}
+		seen[info.Name] = true
+		p, hash := tx.verifyEntry(info.Name)
+		if p != nil {
+			broken = append(broken, len(probs))
+			probs = append(probs, *p)
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		if i, ok := m.index[info.Name]; !ok {
+			probs = append(probs, Problem{Tx: tx.Name, Name: info.Name, Kind: ProblemManifest, Detail: "not in manifest"})
+		} else if m.Entries[i].ContentHash != hash {
+			probs = append(probs, Problem{Tx: tx.Name, Name: info.Name, Kind: ProblemManifest, Detail: "content hash mismatch"})
+		}
+	}
+	if m != nil {
+		for _, ent := range m.Entries {
+			if !seen[ent.Name] {
+				probs = append(probs, Problem{Tx: tx.Name, Name: ent.Name, Kind: ProblemManifest, Detail: "missing file"})
+			}
+		}
+	}
+	if fix == FixNone || len(probs) == 0 {
+		return probs, nil
+	}
+
+	unlock, ok, err := c.tryLockTx(tx.Name)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return probs, nil
+	}
+	defer unlock()
+	for _, i := range broken {
+		if probs[i].Action, err = tx.fixEntry(probs[i], fix); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.repairManifest(m); err != nil {
+		return nil, err
+	}
+	for i := range probs {
+		if probs[i].Kind == ProblemManifest {
+			probs[i].Action = "rebuilt manifest"
+		}
+	}
+	return probs, nil
+}
+
+// verifyEntry はキャッシュファイルを検査し 問題がなければボディの SHA-256 を返します.
+func (tx *Tx) verifyEntry(name string) (*Problem, string) {
+	problem := func(kind, detail string) (*Problem, string) {
+		return &Problem{Tx: tx.Name, Name: name, Kind: kind, Detail: detail}, ""
+	}
+
+	creq, cres, body, err := tx.load(name)
+	if err != nil {
+		return problem(ProblemUnreadable, err.Error())
+	}
+	defer body.Close()
+	dw := newDigestWriter()
+	if _, err = io.Copy(dw, body); err != nil {
+		return problem(ProblemUnreadable, err.Error())
+	}
+	if ident := creq.ident(); !creq.Redacted && ident != name {
+		return problem(ProblemNameMismatch, "want "+ident)
+	}
+	if hasBody(creq.Method, cres.StatusCode) && cres.ContentLength > 0 && cres.ContentLength != dw.n {
+		return problem(ProblemLengthMismatch, fmt.Sprintf("body is %d bytes, want %d", dw.n, cres.ContentLength))
+	}
+	return nil, dw.digest()
+}
+
+// hasBody はレスポンスにボディが含まれるかを返します.
+func hasBody(method string, code int) bool {
+	return method != http.MethodHead && code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// fixEntry は問題のあるキャッシュファイルに対処し 対処した内容を返します.
+func (tx *Tx) fixEntry(p Problem, fix Fix) (string, error) {
+	if fix == FixQuarantine {
+		if err := tx.c.moveEntry(tx.Name, p.Name, quarantineTx, tx.Name+"-"+p.Name); err != nil {
+			return "", err
+		}
+		return "quarantined", nil
+	}
+	if p.Kind == ProblemNameMismatch {
+		// 正しい名前のキャッシュファイルがなければ名前を変える
+		ident := strings.TrimPrefix(p.Detail, "want ")
+		if _, err := tx.st.Stat(tx.Name, ident); isNotExist(err) {
+			if err = tx.c.moveEntry(tx.Name, p.Name, tx.Name, ident); err != nil {
+				return "", err
+			}
+			return "renamed to " + ident, nil
+		}
+	}
+	if err := tx.st.Delete(tx.Name, p.Name); err != nil {
+		return "", err
+	}
+	return "removed", nil
+}
+
+// repairManifest はキャッシュファイルからマニフェストを作り直します.
+//
+// 内容が変わっていないエントリは old の取得日時と順序を引き継ぎます.
+func (tx *Tx) repairManifest(old *manifest) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	m, err := tx.rebuildManifest()
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		for i, ent := range m.Entries {
+			if j, ok := old.index[ent.Name]; ok && old.Entries[j].ContentHash == ent.ContentHash {
+				m.Entries[i] = old.Entries[j]
+			}
+		}
+		sort.SliceStable(m.Entries, func(i, j int) bool {
+			return m.Entries[i].FetchAt.Before(m.Entries[j].FetchAt)
+		})
+	}
+	m.reindex()
+	tx.manifest = m
+	return tx.saveManifest()
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	c, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.NewTransaction(WithName("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := http.NewRequest(http.MethodGet, "https://example.com/x", nil)
+	y, _ := http.NewRequest(http.MethodGet, "https://example.com/y", nil)
+	z, _ := http.NewRequest(http.MethodGet, "https://example.com/z", nil)
+	storeResponse(t, tx, x, http.StatusOK, "x")
+	cy, _ := tx.NewFile(y)
+	err = cy.Store(&http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: 10,
+		Body:          io.NopCloser(strings.NewReader("0123456789")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cz := storeResponse(t, tx, z, http.StatusOK, "z")
+	tx.Release()
+
+	// y を切り詰め z の名前を変え 読めないファイルと登録されていないトランザクションを作る
+	st := c.Storage()
+	r, _ := st.Get("a", cy.Name())
+	b, _ := io.ReadAll(r)
+	r.Close()
+	st.Put("a", cy.Name(), strings.NewReader(string(b[:len(b)-3])))
+	wrong := strings.Repeat("0", 32)
+	c.moveEntry("a", cz.Name(), "a", wrong)
+	garbage := strings.Repeat("f", 32)
+	st.Put("a", garbage, strings.NewReader("garbage"))
+	st.Put("orphan", "manifest.json", strings.NewReader(`{"entries":[]}`))
+
+	kinds := func(probs []Problem) []string {
+		var kinds []string
+		for _, p := range probs {
+			kinds = append(kinds, p.Kind+" "+p.Action)
+		}
+		sort.Strings(kinds)
+		return kinds
+	}
+	// 報告するだけなら読み取り専用でも検査できること
+	ro, err := OpenReadOnly(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probs, err := ro.Verify(FixNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ro.Verify(FixRepair); err != errReadOnly {
+		t.Errorf("%s = %v, want %v", "read-only repair", err, errReadOnly)
+	}
+	want := []string{
+		"length-mismatch ", "manifest ", "name-mismatch ", "orphan ", "unreadable ",
+	}
+	if got := kinds(probs); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", "problems", got, want)
+	}
+
+	if probs, err = c.Verify(FixRepair); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"length-mismatch removed", "manifest rebuilt manifest", "name-mismatch renamed to " + cz.Name(),
+		"orphan registered", "unreadable removed",
+	}
+	if got := kinds(probs); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", "repaired", got, want)
+	}
+	if probs, err = c.Verify(FixNone); err != nil || len(probs) != 0 {
+		t.Errorf("%s = %v, %v, want none", "problems after repair", probs, err)
+	}
+
+	if tx, err = c.GetTransaction("a"); err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Release()
+	if got := loadBody(t, tx, z); got != "z" {
+		t.Errorf("%s = %q, want %q", "body", got, "z")
+	}
+	ents, _ := tx.Entries()
+	if len(ents) != 2 || ents[0].URL != "https://example.com/x" {
+		t.Errorf("%s = %+v, want x, z", "entries", ents)
+	}
+	if _, err = c.GetTransaction("orphan"); err != nil {
+		t.Errorf("%s = %v, want registered", "orphan", err)
+	}
+}
+
+func TestVerifyQuarantine(t *testing.T) {
+	c := NewMemory(10)
+	tx, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Release()
+	garbage := strings.Repeat("f", 32)
+	c.st.Put(tx.Name, garbage, strings.NewReader("garbage"))
+	c.st.Put("orphan", garbage, strings.NewReader("garbage"))
+
+	probs, err := c.Verify(FixQuarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probs) != 2 || probs[0].Action != "quarantined" || probs[1].Action != "quarantined" {
+		t.Errorf("%s = %v, want 2 quarantined", "problems", probs)
+	}
+	infos, _ := c.st.List(quarantineTx)
+	if len(infos) != 2 {
+		t.Errorf("%s = %v, want %d files", "quarantine", infos, 2)
+	}
+	if names, _ := c.st.ListTx(); !reflect.DeepEqual(names, []string{quarantineTx, tx.Name}) {
+		t.Errorf("%s = %v, want %v", "ListTx", names, []string{quarantineTx, tx.Name})
+	}
+}
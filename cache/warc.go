@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warcVersion は出力する WARC のバージョンです.
+const warcVersion = "WARC/1.1"
+
+var (
+	errWarcFormat = errors.New("invalid warc format")
+)
+
+// warcRecord は WARC のレコードを表します.
+type warcRecord struct {
+	header textproto.MIMEHeader
+	block  []byte
+}
+
+// ExportWARC はトランザクションに記録されたリクエストを WARC 形式で w に出力します.
+//
+// リクエスト毎に request レコードと response レコードを出力します.
+// WARC-Date には取得日時を WARC-Payload-Digest にはボディの SHA-1 を出力します.
+// 出力した WARC は ImportWARC で読み込めます. 記録したネットワークエラーは出力しません.
+func (tx *Tx) ExportWARC(w io.Writer) error {
+	ents, err := tx.AllEntries()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	info := fmt.Sprintf("software: go-crawlb\r\nformat: WARC File Format 1.1\r\ntransaction: %s\r\n", tx.Name)
+	err = writeWarcRecord(bw, "warcinfo", "", time.Now(), "application/warc-fields", nil, []byte(info))
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		if ent.Error != "" {
+			// ネットワークエラーは response レコードで表せない
+			continue
+		}
+		if err = tx.writeWarcEntry(bw, ent); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeWarcEntry はエントリを request レコードと response レコードとして出力します.
+func (tx *Tx) writeWarcEntry(w io.Writer, ent Entry) error {
+	src := (&File{tx: tx, name: ent.Name}).source()
+	if src == nil {
+		return notExist("export", tx.Name, ent.Name)
+	}
+	creq, cres, r, err := src.load(ent.Name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(creq.Url)
+	if err != nil {
+		return err
+	}
+	reqid := newRecordID()
+	var req bytes.Buffer
+	payload := creq.body()
+	fmt.Fprintf(&req, "%s %s HTTP/1.1\r\nHost: %s\r\n", creq.Method, u.RequestURI(), u.Host)
+	reqHeader := creq.Header.Clone()
+	if reqHeader == nil {
+		reqHeader = make(http.Header)
+	}
+	reqHeader.Del("Host")
+	if payload != nil {
+		reqHeader.Set("Content-Length", strconv.Itoa(len(payload)))
+	}
+	reqHeader.Write(&req)
+	req.WriteString("\r\n")
+	req.Write(payload)
+	err = writeWarcRecord(w, "request", creq.Url, ent.FetchAt, "application/http;msgtype=request",
+		textproto.MIMEHeader{"WARC-Record-ID": {reqid}}, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// 保存したボディは転送時の符号化を解除しているため ヘッダをボディに合わせる
+	header := cres.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	proto := cres.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	status := cres.Status
+	if !strings.HasPrefix(status, strconv.Itoa(cres.StatusCode)) {
+		status = fmt.Sprintf("%d %s", cres.StatusCode, http.StatusText(cres.StatusCode))
+	}
+	var resp bytes.Buffer
+	fmt.Fprintf(&resp, "%s %s\r\n", proto, status)
+	header.Write(&resp)
+	resp.WriteString("\r\n")
+	resp.Write(body)
+
+	return writeWarcRecord(w, "response", creq.Url, ent.FetchAt, "application/http;msgtype=response",
+		textproto.MIMEHeader{
+			"WARC-Concurrent-To":  {reqid},
+			"WARC-Payload-Digest": {warcDigest(body)},
+		}, resp.Bytes())
+}
+
+// writeWarcRecord は WARC のレコードを出力します.
+func writeWarcRecord(w io.Writer, typ, target string, date time.Time, ctype string, extra textproto.MIMEHeader, block []byte) error {
+	h := textproto.MIMEHeader{}
+	h.Set("WARC-Type", typ)
+	h.Set("WARC-Record-ID", newRecordID())
+	h.Set("WARC-Date", date.UTC().Format(time.RFC3339Nano))
+	if target != "" {
+		h.Set("WARC-Target-URI", target)
+	}
+	h.Set("Content-Type", ctype)
+	h.Set("WARC-Block-Digest", warcDigest(block))
+	h.Set("Content-Length", strconv.Itoa(len(block)))
+	for k, v := range extra {
+		h[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(warcVersion + "\r\n")
+	for _, k := range []string{
+		"WARC-Type", "WARC-Record-ID", "WARC-Date", "WARC-Target-URI", "WARC-Concurrent-To",
+		"Content-Type", "WARC-Block-Digest", "WARC-Payload-Digest", "Content-Length",
+	} {
+		if v := h.Get(k); v != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// warcDigest は WARC の digest 形式で SHA-1 を返します.
+func warcDigest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newRecordID は WARC-Record-ID に使用する UUID を作成します.
+func newRecordID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// ImportWARC は WARC 形式の request, response レコードをトランザクションに保存します.
+//
+// gzip で圧縮された WARC も読み込めます.
+// response レコードは WARC-Concurrent-To で request レコードと対応付け
+// 対応する request レコードがない場合は同じ URL への GET として保存します.
+func (tx *Tx) ImportWARC(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		br = bufio.NewReader(gr)
+	}
+
+	reqs := make(map[string]*warcRecord)
+	for i := 0; ; i++ {
+		rec, err := readWarcRecord(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("import warc record #%d: %w", i, err)
+		}
+		switch rec.header.Get("WARC-Type") {
+		case "request":
+			reqs[rec.header.Get("WARC-Record-ID")] = rec
+		case "response":
+			reqrec := reqs[rec.header.Get("WARC-Concurrent-To")]
+			if err = tx.importWarcResponse(reqrec, rec); err != nil {
+				return fmt.Errorf("import warc record #%d: %w", i, err)
+			}
+		}
+	}
+}
+
+// readWarcRecord は WARC のレコードを 1 つ読み込みます.
+func readWarcRecord(br *bufio.Reader) (*warcRecord, error) {
+	// レコード間の空行を読み飛ばす
+	var line string
+	for {
+		l, err := br.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		if line = strings.TrimRight(l, "\r\n"); line != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, errWarcFormat
+	}
+
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return nil, errWarcFormat
+	}
+	// Content-Length を信用して先に確保せず 実際に読めた分だけ読み込む
+	block, err := io.ReadAll(io.LimitReader(br, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(block)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return &warcRecord{header, block}, nil
+}
+
+// importWarcResponse は response レコードをトランザクションに保存します.
+func (tx *Tx) importWarcResponse(reqrec, resprec *warcRecord) error {
+	target := resprec.header.Get("WARC-Target-URI")
+	var req *http.Request
+	if reqrec != nil {
+		r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(reqrec.block)))
+		if err != nil {
+			return err
+		}
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var body io.Reader
+		if len(payload) > 0 {
+			body = bytes.NewReader(payload)
+		}
+		if req, err = http.NewRequest(r.Method, target, body); err != nil {
+			return err
+		}
+		req.Header = r.Header
+	} else {
+		var err error
+		if req, err = http.NewRequest(http.MethodGet, target, nil); err != nil {
+			return err
+		}
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resprec.block)), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fetchAt, err := time.Parse(time.RFC3339Nano, resprec.header.Get("WARC-Date"))
+	if err != nil {
+		fetchAt = time.Now()
+	}
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		return err
+	}
+	return cf.store(resp, fetchAt)
+}
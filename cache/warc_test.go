@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWARC(t *testing.T) {
+	c := NewMemory(3)
+	src, err := c.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := func(req *http.Request, body string) {
+		cf, err := src.NewFile(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cf.Store(&http.Response{
+			Status:           "200 OK",
+			StatusCode:       http.StatusOK,
+			Proto:            "HTTP/1.1",
+			Header:           http.Header{"Content-Type": {"text/plain"}},
+			TransferEncoding: []string{"chunked"},
+			Body:             io.NopCloser(strings.NewReader(body)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	get, _ := http.NewRequest(http.MethodGet, "https://example.com/?q=1", nil)
+	post, _ := http.NewRequest(http.MethodPost, "https://example.com/login", strings.NewReader("user=a"))
+	store(get, "hello")
+	store(post, "welcome")
+
+	var buf bytes.Buffer
+	if err = src.ExportWARC(&buf); err != nil {
+		t.Fatal(err)
+	}
+	warc := buf.String()
+	for _, s := range []string{
+		"WARC-Type: warcinfo", "WARC-Type: request", "WARC-Type: response",
+		"WARC-Payload-Digest: sha1:", "WARC-Target-URI: https://example.com/login",
+	} {
+		if !strings.Contains(warc, s) {
+			t.Errorf("%s does not contain %q", "warc", s)
+		}
+	}
+
+	// gzip で圧縮した WARC も読み込めること
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	for _, r := range []io.Reader{strings.NewReader(warc), &gz} {
+		dst, err := c.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = dst.ImportWARC(r); err != nil {
+			t.Fatal(err)
+		}
+		sents, _ := src.Entries()
+		dents, err := dst.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dents) != len(sents) {
+			t.Fatalf("%s = %d, want %d", "len(entries)", len(dents), len(sents))
+		}
+		for i := range sents {
+			s, d := sents[i], dents[i]
+			if s.Name != d.Name || s.ContentHash != d.ContentHash || !s.FetchAt.Equal(d.FetchAt) {
+				t.Errorf("%s = %+v, want %+v", "imported entry", d, s)
+			}
+		}
+		if got := loadBody(t, dst, post); got != "welcome" {
+			t.Errorf("%s = %q, want %q", "body", got, "welcome")
+		}
+		dst.Release()
+	}
+}
+
+func TestReadWarcRecord(t *testing.T) {
+	// 不正な Content-Length で panic したり大きなメモリを確保しないこと
+	for _, tt := range []struct {
+		length string
+		want   error
+	}{
+		{"-1", errWarcFormat},
+		{"x", errWarcFormat},
+		{"9223372036854775807", io.ErrUnexpectedEOF},
+		{"10", io.ErrUnexpectedEOF},
+	} {
+		rec := "WARC/1.1\r\nWARC-Type: response\r\nContent-Length: " + tt.length + "\r\n\r\nshort"
+		if _, err := readWarcRecord(bufio.NewReader(strings.NewReader(rec))); err != tt.want {
+			t.Errorf("%s(%s) = %v, want %v", "readWarcRecord", tt.length, err, tt.want)
+		}
+	}
+	rec := "WARC/1.1\r\nContent-Length: 5\r\n\r\nshort"
+	if r, err := readWarcRecord(bufio.NewReader(strings.NewReader(rec))); err != nil || string(r.block) != "short" {
+		t.Errorf("%s = %v, %v", "readWarcRecord", r, err)
+	}
+}
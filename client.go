@@ -32,10 +32,89 @@ var (
 // この仕組みによって障害発生時を再現したり サーバに負担を掛けずに開発・テストができます.
 // トランザクションは最大世代数を超えると自動的に破棄されます.
 type Client struct {
-	ctx   context.Context
-	mu    mutex.Mutex
-	cache *cache.Cache
-	tx    *cache.Tx
+	ctx      context.Context
+	mu       *mutex.Mutex
+	cache    *cache.Cache
+	tx       *cache.Tx
+	negative bool
+	hc       *http.Client
+	policy   Policy
+	rules    []FreshnessRule
+	flights  flightGroup
+	// retention は NewClient が作成する Cache の保持ルール
+	retention []cache.Rule
+}
+
+// Policy はキャッシュを再利用する方針を表します.
+type Policy int
+
+const (
+	// PolicyGeneration は使用中のトランザクションに記録された結果だけを再利用します.
+	PolicyGeneration Policy = iota
+
+	// PolicyHTTP は使用中のトランザクションに無い場合 RFC 9111 に従って
+	// 他のトランザクションに記録された結果も再利用します.
+	//
+	// Cache-Control, Expires, Age と Vary から新鮮と判断できる結果を
+	// 使用中のトランザクションに複製するため 長時間のジョブを再開しても
+	// まだ新鮮なリソースを取得し直さずに済みます.
+	// 鮮度が切れた結果は再検証せずに取得し直します.
+	PolicyHTTP
+)
+
+// Option は NewClient に渡すオプションです.
+type Option func(cl *Client)
+
+// WithCache は Client が使用する Cache を指定します.
+//
+// このオプションを指定すると NewClient の cacheDir, numTx は使用されません.
+// cache.NewMemory と組み合わせるとファイルシステムを使用しない Client を作成できます.
+func WithCache(c *cache.Cache) Option {
+	return func(cl *Client) {
+		cl.cache = c
+	}
+}
+
+// WithRetention は NewClient が作成する Cache の保持ルールを指定します.
+//
+// 指定すると numTx は使用されず 作成時の破棄から rules が適用されます.
+// WithCache と同時に指定した場合は無視されます.
+func WithRetention(rules ...cache.Rule) Option {
+	return func(cl *Client) {
+		cl.retention = append(cl.retention, rules...)
+	}
+}
+
+// WithNegativeCache は名前解決の失敗やタイムアウトなどのネットワークエラーも
+// トランザクションに記録します.
+//
+// 記録したエラーは再生時に同じ種類のエラーとして返されるため
+// 障害が発生した時と同じ経路を辿って再現できます.
+// 記録したエラーは このオプションを指定していない Client でも再生されます.
+func WithNegativeCache() Option {
+	return func(cl *Client) {
+		cl.negative = true
+	}
+}
+
+// WithRedirectPolicy は Client がリダイレクトに従うかを判断する関数を指定します.
+//
+// policy は http.Client の CheckRedirect と同じ意味を持ちます.
+// http.ErrUseLastResponse を返すとリダイレクトに従わず 3xx のレスポンスを記録します.
+// 指定しない場合は http.Client と同様に 10 回までリダイレクトに従います.
+func WithRedirectPolicy(policy func(req *http.Request, via []*http.Request) error) Option {
+	return func(cl *Client) {
+		cl.hc = &http.Client{CheckRedirect: policy}
+	}
+}
+
+// WithPolicy はキャッシュを再利用する方針を指定します.
+//
+// 指定しない場合は PolicyGeneration です.
+func WithPolicy(p Policy) Option {
+	return func(cl *Client) {
+		cl.policy = p
+	}
 }
 
 // NewClient は新しい Client を作成します.
@@ -43,36 +122,57 @@ type Client struct {
 // サーバへのアクセス間隔は d で指定します.
 // キャッシュ機構のディレクトリやトランザクションの最大世代数はそれぞれ
 // cacheDir, numTx で指定します.
-func NewClient(ctx context.Context, d time.Duration, cacheDir string, numTx int) (*Client, error) {
-	cache, err := cache.New(cacheDir, numTx)
-	if err != nil {
-		return nil, err
+func NewClient(ctx context.Context, d time.Duration, cacheDir string, numTx int, opts ...Option) (*Client, error) {
+	cl := &Client{
+		ctx: ctx,
+		mu:  mutex.New(d),
+		hc:  http.DefaultClient,
 	}
-	return &Client{
-		ctx:   ctx,
-		mu:    *mutex.New(d),
-		cache: cache,
-	}, nil
+	for _, opt := range opts {
+		opt(cl)
+	}
+	if cl.cache == nil {
+		var c *cache.Cache
+		var err error
+		if cl.retention != nil {
+			c, err = cache.NewWithRetention(cache.NewDirStorage(cacheDir), cl.retention...)
+		} else {
+			c, err = cache.New(cacheDir, numTx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cl.cache = c
+	}
+	return cl, nil
+}
+
+// Cache は Client が使用する Cache を返します.
+func (cl *Client) Cache() *cache.Cache {
+	return cl.cache
 }
 
 // NewTransaction は新しいトランザクションを開始し世代を切り替えます.
-func (cl *Client) NewTransaction() error {
-	tx, err := cl.cache.NewTransaction()
+//
+// opts でトランザクション名やラベルを指定できます.
+func (cl *Client) NewTransaction(opts ...cache.TxOption) error {
+	tx, err := cl.cache.NewTransaction(opts...)
 	if err != nil {
 		return err
 	}
-	cl.tx = tx
-	return nil
+	return cl.useTx(tx)
 }
 
 // LastTransaction は前回のトランザクションを再開します.
-func (cl *Client) LastTransaction() error {
-	tx, err := cl.cache.GetLastTransaction()
+//
+// filters を指定すると条件を満たす最後のトランザクションを使用します.
+// 例えば cache.Committed を指定すると最後にコミットされたトランザクションを使用します.
+func (cl *Client) LastTransaction(filters ...cache.Filter) error {
+	tx, err := cl.cache.GetLastTransaction(filters...)
 	if err != nil {
 		return err
 	}
-	cl.tx = tx
-	return nil
+	return cl.useTx(tx)
 }
 
 // SetTransaction は指定したトランザクションを使用します.
@@ -81,12 +181,84 @@ func (cl *Client) SetTransaction(name string) error {
 	if err != nil {
 		return err
 	}
+	return cl.useTx(tx)
+}
+
+// Fork は指定したトランザクションをフォークした新しいトランザクションを使用します.
+//
+// フォーク元を変更せずに 過去のトランザクションの上で新しいリクエストを試すことができます.
+func (cl *Client) Fork(name string, opts ...cache.TxOption) error {
+	tx, err := cl.cache.Fork(name, opts...)
+	if err != nil {
+		return err
+	}
+	return cl.useTx(tx)
+}
+
+// Commit は使用中のトランザクションの記録を完了し封印します.
+func (cl *Client) Commit() error {
+	if cl.tx == nil {
+		return errNotStartedTx
+	}
+	return cl.tx.Commit()
+}
+
+// Seal は使用中のトランザクションを封印し 読み取り専用にします.
+func (cl *Client) Seal() error {
+	if cl.tx == nil {
+		return errNotStartedTx
+	}
+	return cl.tx.Seal()
+}
+
+// Abort は使用中のトランザクションの記録を中断します.
+func (cl *Client) Abort() error {
+	if cl.tx == nil {
+		return errNotStartedTx
+	}
+	return cl.tx.Abort()
+}
+
+// useTx は使用するトランザクションを切り替えます.
+//
+// それまで使用していたトランザクションは使用を終了し 破棄できる状態に戻します.
+func (cl *Client) useTx(tx *cache.Tx) error {
+	if cl.tx != nil && cl.tx != tx {
+		if err := cl.tx.Release(); err != nil {
+			return err
+		}
+	}
 	cl.tx = tx
 	return nil
 }
 
+// clone はトランザクションを使用していない Client を作成します.
+//
+// 作成した Client は Cache やアクセス間隔などの設定を cl と共有し
+// cl と合わせてサーバへのアクセス間隔を守ります.
+func (cl *Client) clone() *Client {
+	return &Client{
+		ctx:       cl.ctx,
+		mu:        cl.mu,
+		cache:     cl.cache,
+		negative:  cl.negative,
+		hc:        cl.hc,
+		policy:    cl.policy,
+		rules:     cl.rules,
+		retention: cl.retention,
+	}
+}
+
 // Do は http.Request を送信し http.Response を返します.
 // もしトランザクションにキャッシュがあれば キャッシュされた結果を返します.
+//
+// WithFreshness で有効期間を指定した場合 有効期間を過ぎたキャッシュは取得し直します.
+// 複数の goroutine から同じリクエストを同時に発行した場合 サーバへのアクセスは 1 回にまとめ
+// それぞれに独立したボディを返します.
+//
+// トランザクションが封印されている場合 キャッシュがなければサーバにアクセスせず
+// cache.ErrSealed を返します.
+// ネットワークエラーが記録されている場合は *cache.NetError をラップした *url.Error を返します.
 func (cl *Client) Do(req *http.Request) (*http.Response, error) {
 	if cl.tx == nil {
 		return nil, errNotStartedTx
@@ -96,39 +268,77 @@ func (cl *Client) Do(req *http.Request) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err = cl.fetchAndStore(cf, req); err != nil {
+	if err = cl.flights.fetchAndStore(cl, cf, req); err != nil {
 		return nil, err
 	}
-	return cf.Load()
+	resp, err := cf.Load()
+	var ne *cache.NetError
+	if errors.As(err, &ne) {
+		// http.Client と同じ形のエラーで返す
+		return nil, &url.Error{Op: urlErrorOp(req.Method), URL: req.URL.String(), Err: ne}
+	}
+	return resp, err
+}
+
+// urlErrorOp は url.Error の Op に設定するメソッド名を返します.
+func urlErrorOp(method string) string {
+	if method == "" {
+		return "Get"
+	}
+	return method[:1] + strings.ToLower(method[1:])
 }
 
 // fetchAndStore は実際に http.Request を送信し http.Response をキャッシュを保存します.
+//
+// アクセス間隔を空けるために待った時間と httptrace で計測した所要時間も記録します.
 func (cl *Client) fetchAndStore(cf *cache.File, req *http.Request) error {
 	var (
 		resp *http.Response
 		err  error
 	)
 
-	if cf.IsExists() {
-		return nil
+	exists := cf.IsExists()
+	if exists {
+		expired, err := cl.expired(cf, req.URL)
+		if err != nil {
+			return err
+		}
+		if !expired || cl.tx.IsSealed() {
+			return nil
+		}
+	}
+	if cl.tx.IsSealed() {
+		return fmt.Errorf("%s %q: %w", req.Method, req.URL, cache.ErrSealed)
+	}
+	if cl.policy == PolicyHTTP && !exists {
+		if ok, err := cf.Reuse(time.Now()); ok || err != nil {
+			return err
+		}
 	}
 
+	waitStart := time.Now()
 	if err = cl.mu.Lock(cl.ctx); err != nil {
 		return err
 	}
 	defer cl.mu.Unlock()
+	tr := newTracer(time.Since(waitStart))
 
 	if req.Method == http.MethodGet && req.URL.Scheme == "file" {
 		resp, err = fileResponse(path.Join("/", req.URL.Host, req.URL.Path))
 	} else {
-		resp, err = http.DefaultClient.Do(req)
+		resp, err = cl.hc.Do(tr.withTrace(req))
+		if ne := cache.NewNetError(err); ne != nil && cl.negative {
+			if serr := cf.StoreError(ne); serr != nil {
+				return serr
+			}
+		}
 	}
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	return cf.Store(resp)
+	return cf.StoreWithTiming(resp, tr.timing(resp))
 }
 
 // Get は指定された URL に対して GET を発行します.
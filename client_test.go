@@ -1,10 +1,24 @@
 package crawlb
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/17e10/go-crawlb/cache"
 )
 
 func TestClient(t *testing.T) {
@@ -47,3 +61,499 @@ func TestClient(t *testing.T) {
 	}
 	resp.Body.Close()
 }
+
+func TestClientMemory(t *testing.T) {
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprintf(w, "%s %s #%d", r.Method, r.URL.Path, hits)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() string {
+		resp, err := cl.Get(ts.URL + "/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	first := cl.tx.Name
+	for i := 0; i < 2; i++ {
+		if got, want := get(), "GET /page #1"; got != want {
+			t.Errorf("%s = %q, want %q", "record", got, want)
+		}
+	}
+
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(), "GET /page #2"; got != want {
+		t.Errorf("%s = %q, want %q", "new transaction", got, want)
+	}
+
+	if err = cl.SetTransaction(first); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(), "GET /page #1"; got != want {
+		t.Errorf("%s = %q, want %q", "replay", got, want)
+	}
+	if hits != 2 {
+		t.Errorf("%s = %d, want %d", "hits", hits, 2)
+	}
+}
+
+func TestClientSealed(t *testing.T) {
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cl.Get(ts.URL + "/recorded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err = cl.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp, err = cl.Get(ts.URL + "/recorded"); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err = cl.Get(ts.URL + "/missing"); !errors.Is(err, cache.ErrSealed) {
+		t.Errorf("%s = %v, want %v", "miss", err, cache.ErrSealed)
+	}
+	if hits != 1 {
+		t.Errorf("%s = %d, want %d", "hits", hits, 1)
+	}
+}
+
+func TestClientNegativeCache(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)), WithNegativeCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cl.Get(ts.URL); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("%s = %v, want %v", "first", err, syscall.ECONNREFUSED)
+	}
+	want := err.Error()
+	if err = cl.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 封印したトランザクションから同じエラーを再生すること
+	_, err = cl.Get(ts.URL)
+	var ue *url.Error
+	if !errors.As(err, &ue) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("%s = %#v, want *url.Error wrapping %v", "replay", err, syscall.ECONNREFUSED)
+	}
+	if err.Error() != want {
+		t.Errorf("%s = %q, want %q", "replay", err.Error(), want)
+	}
+	ents, _ := cl.tx.Entries()
+	if len(ents) != 1 || ents[0].Error == "" {
+		t.Errorf("%s = %+v, want 1 error entry", "entries", ents)
+	}
+}
+
+func TestClientRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "c") })
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cl.Get(ts.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append(chain, req.URL.Path)
+		if req.Response == nil {
+			break
+		}
+		chain = append(chain, strconv.Itoa(req.Response.StatusCode))
+		req = req.Response.Request
+	}
+	if want := []string{"/c", "301", "/b", "302", "/a"}; !reflect.DeepEqual(chain, want) {
+		t.Errorf("%s = %v, want %v", "chain", chain, want)
+	}
+	if loc := resp.Request.Response.Header.Get("Location"); loc != "/c" {
+		t.Errorf("%s = %q, want %q", "Location", loc, "/c")
+	}
+
+	// リダイレクトに従わない場合は 3xx のレスポンスを記録すること
+	cl, err = NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)),
+		WithRedirectPolicy(func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = cl.Get(ts.URL + "/a"); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Request.URL.Path != "/a" || resp.Request.Response != nil {
+		t.Errorf("%s = %d %s, want %d %s", "no redirect", resp.StatusCode, resp.Request.URL.Path, http.StatusFound, "/a")
+	}
+}
+
+func TestClientTiming(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	c := cache.NewMemory(3)
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := cl.Get(ts.URL + "/" + strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		tm := cache.ResponseTiming(resp)
+		if tm == nil {
+			t.Fatalf("%s = nil", "ResponseTiming")
+		}
+		if tm.FirstByte < 10*time.Millisecond || tm.Total < tm.FirstByte {
+			t.Errorf("%s = %v, %v", "FirstByte, Total", tm.FirstByte, tm.Total)
+		}
+		if tm.RemoteAddr != ts.Listener.Addr().String() {
+			t.Errorf("%s = %q, want %q", "RemoteAddr", tm.RemoteAddr, ts.Listener.Addr())
+		}
+		// 2 回目は接続を再利用すること
+		if tm.Reused != (i == 1) {
+			t.Errorf("%s = %v, want %v", "Reused", tm.Reused, i == 1)
+		}
+	}
+
+	// HAR に所要時間が出力されること
+	var buf bytes.Buffer
+	if err = cl.tx.ExportHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var h struct {
+		Log struct {
+			Entries []struct {
+				Timings struct{ Wait float64 }
+			}
+		}
+	}
+	if err = json.Unmarshal(buf.Bytes(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Log.Entries) != 2 || h.Log.Entries[0].Timings.Wait < 10 {
+		t.Errorf("%s = %+v", "har timings", h.Log.Entries)
+	}
+
+	// マニフェストを作り直しても Total 以外の所要時間が残ること
+	for _, name := range []string{"manifest.json", "manifest.log"} {
+		c.Storage().Delete(cl.tx.Name, name)
+	}
+	c2, err := cache.NewWithStorage(c.Storage(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c2.GetTransaction(cl.tx.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err := tx.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 2 || ents[0].Timing == nil || ents[0].Timing.FirstByte < 10*time.Millisecond || ents[0].Timing.RemoteAddr == "" {
+		t.Errorf("%s = %+v", "rebuilt entries", ents)
+	}
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/0", nil)
+	cf, err := tx.NewFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if tm := cache.ResponseTiming(resp); tm == nil || tm.FirstByte != ents[0].Timing.FirstByte {
+		t.Errorf("%s = %+v, want %+v", "ResponseTiming", tm, ents[0].Timing)
+	}
+}
+
+func TestClientPolicyHTTP(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/fresh" {
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	c := cache.NewMemory(3)
+	for i, policy := range []Policy{PolicyHTTP, PolicyHTTP, PolicyGeneration} {
+		cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(c), WithPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cl.NewTransaction(); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{"/fresh", "/none"} {
+			resp, err := cl.Get(ts.URL + p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		if err = cl.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		// 新鮮な /fresh は 2 回目から PolicyHTTP の場合だけ再利用されること
+		if want := []int{2, 3, 5}[i]; hits != want {
+			t.Errorf("%s = %d, want %d", "hits", hits, want)
+		}
+	}
+}
+
+func TestClientFreshness(t *testing.T) {
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		fmt.Fprint(w, hits[r.URL.Path])
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)),
+		WithFreshness(
+			FreshnessRule{Host: "127.0.0.*", Path: "/stock/", TTL: 50 * time.Millisecond},
+			FreshnessRule{Path: "/*.json", TTL: time.Hour},
+		))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	get := func(p string) string {
+		resp, err := cl.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	paths := []string{"/stock/list", "/data.json", "/static"}
+	for _, p := range paths {
+		get(p)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for _, p := range paths {
+		get(p)
+	}
+	// 有効期間を過ぎたエントリだけ取得し直すこと
+	if want := map[string]int{"/stock/list": 2, "/data.json": 1, "/static": 1}; !reflect.DeepEqual(hits, want) {
+		t.Errorf("%s = %v, want %v", "hits", hits, want)
+	}
+	if got := get("/stock/list"); got != "2" {
+		t.Errorf("%s = %q, want %q", "body", got, "2")
+	}
+
+	// 封印されたトランザクションは有効期間を過ぎても記録された結果を返すこと
+	if err = cl.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := get("/stock/list"); got != "2" || hits["/stock/list"] != 2 {
+		t.Errorf("%s = %q, want %q", "sealed body", got, "2")
+	}
+}
+
+func TestClientCoalesce(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	const n = 8
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := cl.Get(ts.URL)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			bodies[i], errs[i] = string(b), err
+		}(i)
+	}
+	wg.Wait()
+	// サーバへのアクセスは 1 回で 全員が同じボディを読めること
+	if hits != 1 {
+		t.Errorf("%s = %d, want %d", "hits", hits, 1)
+	}
+	for i := 0; i < n; i++ {
+		if errs[i] != nil || bodies[i] != "hello" {
+			t.Errorf("%s[%d] = %q, %v, want %q", "body", i, bodies[i], errs[i], "hello")
+		}
+	}
+}
+
+func TestClientPolicyHTTPConcurrent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	c, err := cache.New(t.TempDir(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(c), WithPolicy(PolicyHTTP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	// 他のトランザクションを探す間も使用中のトランザクションを読み書きできること
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := cl.Get(ts.URL + "/" + strconv.Itoa(i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestClientCoalesceCanceled(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// 最初のリクエストはキャンセルされるまで応答しない
+			arrived <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.NewTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		_, err := cl.Do(req)
+		leader <- err
+	}()
+	<-arrived
+
+	waiter := make(chan string, 1)
+	go func() {
+		resp, err := cl.Get(ts.URL)
+		if err != nil {
+			waiter <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		waiter <- string(b)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	// キャンセルされたのは最初の呼び出し元だけで 待っていた呼び出し元は取得し直すこと
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("%s = %v, want %v", "leader", err, context.Canceled)
+	}
+	if got := <-waiter; got != "hello" {
+		t.Errorf("%s = %q, want %q", "waiter", got, "hello")
+	}
+}
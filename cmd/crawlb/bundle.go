@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// runExport はトランザクションをバンドルに出力します.
+//
+// 出力ファイル名が .zip で終わる場合は zip 形式 それ以外は tar.gz 形式で出力します.
+func runExport(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "", "output bundle file (.tar.gz or .zip)")
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil || *out == "" || fs.NArg() == 0 {
+		return errUsage
+	}
+
+	format := cache.TarGz
+	if strings.HasSuffix(*out, ".zip") {
+		format = cache.Zip
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err = c.ExportBundle(f, format, fs.Args()...); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	return f.Close()
+}
+
+// runImport はバンドルをキャッシュディレクトリに取り込みます.
+func runImport(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	pin := fs.Bool("pin", false, "pin imported transactions")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	names, err := c.ImportBundle(f)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if *pin {
+			if err = c.Pin(name); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "imported %s\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// runDiff は 2 つのトランザクションの差分を表示します.
+func runDiff(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	text := fs.Bool("text", false, "show unified diffs of text bodies")
+	context := fs.Int("context", 3, "lines of context")
+	header := fs.String("header", "", "comma separated response headers to compare")
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+
+	a, err := c.GetTransaction(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := c.GetTransaction(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	opts := &cache.DiffOptions{TextDiff: *text, Context: *context}
+	if *header != "" {
+		opts.Headers = strings.Split(*header, ",")
+	}
+	changes, err := cache.Diff(a, b, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range changes {
+		mark := map[cache.ChangeKind]string{cache.Added: "+", cache.Removed: "-", cache.Modified: "~"}[ch.Kind]
+		fmt.Fprintf(w, "%s %s %s\n", mark, ch.Method, ch.URL)
+		if ch.StatusChanged() {
+			fmt.Fprintf(w, "    status: %d -> %d\n", ch.Old.StatusCode, ch.New.StatusCode)
+		}
+		if ch.ErrorChanged() {
+			fmt.Fprintf(w, "    error: %q -> %q\n", ch.Old.Error, ch.New.Error)
+		}
+		for _, h := range ch.Headers {
+			fmt.Fprintf(w, "    %s: %q -> %q\n", strings.ToLower(h.Name), h.Old, h.New)
+		}
+		if ch.BodyChanged() {
+			fmt.Fprintf(w, "    body: %.12s (%d bytes) -> %.12s (%d bytes)\n",
+				ch.Old.ContentHash, ch.Old.Size, ch.New.ContentHash, ch.New.Size)
+		}
+		for _, line := range splitLines(ch.TextDiff) {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+	fmt.Fprintf(w, "%d changes\n", len(changes))
+	return nil
+}
+
+// splitLines は s を行に分割します.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// runFsck はキャッシュの整合性を検査します.
+//
+// 対処していない問題が残っている場合はエラーを返します.
+func runFsck(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "repair or remove broken entries and register orphans")
+	quarantine := fs.Bool("quarantine", false, "move broken entries and orphans to quarantine")
+	if err := parseFlags(fs, args, 0); err != nil || *repair && *quarantine {
+		return errUsage
+	}
+
+	fix := cache.FixNone
+	if *repair {
+		fix = cache.FixRepair
+	} else if *quarantine {
+		fix = cache.FixQuarantine
+	}
+	probs, err := c.Verify(fix)
+	if err != nil {
+		return err
+	}
+	remain := 0
+	for _, p := range probs {
+		fmt.Fprintln(w, p)
+		if p.Action == "" {
+			remain++
+		}
+	}
+	if remain > 0 {
+		return fmt.Errorf("%d problems found", remain)
+	}
+	return nil
+}
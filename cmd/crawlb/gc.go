@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// gcFlags は gc のフラグです.
+type gcFlags struct {
+	dryRun bool
+	keep   int
+	age    time.Duration
+	size   int64
+	daily  int
+	weekly int
+}
+
+// parseGcFlags は gc のフラグを解析します.
+func parseGcFlags(args []string) (*gcFlags, error) {
+	var f gcFlags
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.BoolVar(&f.dryRun, "n", false, "dry run: show transactions to be discarded")
+	fs.IntVar(&f.keep, "keep", -1, "keep the newest n generations")
+	fs.DurationVar(&f.age, "age", 0, "discard transactions older than the duration")
+	fs.Int64Var(&f.size, "size", 0, "keep total size under the bytes")
+	fs.IntVar(&f.daily, "daily", 0, "keep one committed transaction per day for n days")
+	fs.IntVar(&f.weekly, "weekly", 0, "keep one committed transaction per week for n weeks")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// gcReadOnly は -n を指定した場合と 引数が誤っている場合に読み取り専用で開きます.
+func gcReadOnly(args []string) bool {
+	f, err := parseGcFlags(args)
+	return err != nil || f.dryRun
+}
+
+// runGc は保持ルールに従って古いトランザクションを破棄します.
+func runGc(c *cache.Cache, w io.Writer, args []string) error {
+	f, err := parseGcFlags(args)
+	if err != nil {
+		return err
+	}
+
+	var rules []cache.Rule
+	if f.keep >= 0 {
+		rules = append(rules, cache.KeepGenerations(f.keep))
+	}
+	if f.age > 0 {
+		rules = append(rules, cache.KeepAge(f.age))
+	}
+	if f.size > 0 {
+		rules = append(rules, cache.KeepSize(f.size))
+	}
+	if f.daily > 0 {
+		rules = append(rules, cache.KeepDaily(f.daily))
+	}
+	if f.weekly > 0 {
+		rules = append(rules, cache.KeepWeekly(f.weekly))
+	}
+	if len(rules) == 0 {
+		return errUsage
+	}
+	c.SetRetention(rules...)
+
+	txs, err := c.Discard(f.dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "discarded"
+	if f.dryRun {
+		verb = "would discard"
+	}
+	for _, tx := range txs {
+		fmt.Fprintf(w, "%s %s (%s)\n", verb, tx.Name, tx.CreateAt)
+	}
+	return nil
+}
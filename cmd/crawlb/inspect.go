@@ -0,0 +1,230 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// runList はトランザクションの一覧を表示します.
+func runList(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	label := fs.String("label", "", "show only transactions with the label")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	var filters []cache.Filter
+	if *label != "" {
+		filters = append(filters, cache.HasLabel(*label))
+	}
+	txs, err := c.Find(filters...)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCREATED\tSTATUS\tFLAGS\tENTRIES\tLABELS\tNOTE")
+	for _, tx := range txs {
+		var flags []string
+		if tx.Pinned {
+			flags = append(flags, "pinned")
+		}
+		if tx.IsSealed() {
+			flags = append(flags, "sealed")
+		}
+		if tx.Base != "" {
+			flags = append(flags, "fork:"+tx.Base)
+		}
+		status := tx.State()
+		entries := "-"
+		if tx.Stats != nil {
+			entries = fmt.Sprint(tx.Stats.Entries)
+		} else if ents, err := tx.Entries(); err == nil {
+			entries = fmt.Sprint(len(ents))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", tx.Name, tx.CreateAt, status,
+			strings.Join(flags, ","), entries, strings.Join(tx.Labels, ","), tx.Note)
+	}
+	return tw.Flush()
+}
+
+// runEntries はトランザクションに記録されたリクエストの一覧を表示します.
+func runEntries(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("entries", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	tx, err := c.GetTransaction(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ents, err := tx.AllEntries()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMETHOD\tSTATUS\tSIZE\tFETCHED\tURL")
+	for _, ent := range ents {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", ent.Name, ent.Method, ent.StatusCode,
+			ent.Size, ent.FetchAt.Local().Format("2006-01-02 15:04:05"), ent.URL)
+	}
+	return tw.Flush()
+}
+
+// runShow は記録されたリクエストとレスポンスの情報を表示します.
+func runShow(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	tx, ent, err := findEntry(c, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if ent.Error != "" {
+		fmt.Fprintf(w, "Entry:   %s\n", ent.Name)
+		fmt.Fprintf(w, "Fetched: %s\n", ent.FetchAt.Local().Format("2006-01-02 15:04:05.000"))
+		fmt.Fprintf(w, "\n%s %s\n", ent.Method, ent.URL)
+		fmt.Fprintf(w, "\nError:   %s\n", ent.Error)
+		return nil
+	}
+	resp, err := tx.Open(ent.Name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fmt.Fprintf(w, "Entry:   %s\n", ent.Name)
+	fmt.Fprintf(w, "Fetched: %s\n", ent.FetchAt.Local().Format("2006-01-02 15:04:05.000"))
+	fmt.Fprintf(w, "Size:    %d\n", ent.Size)
+	fmt.Fprintf(w, "SHA-256: %s\n", ent.ContentHash)
+	if ent.PayloadDigest != "" {
+		fmt.Fprintf(w, "Payload: sha256 %s\n", ent.PayloadDigest)
+	}
+	if t := ent.Timing; t != nil {
+		fmt.Fprintf(w, "Timing:  wait %v, dns %v, connect %v, tls %v, first byte %v, total %v\n",
+			t.Wait, t.DNS, t.Connect, t.TLS, t.FirstByte, t.Total)
+		if t.RemoteAddr != "" {
+			fmt.Fprintf(w, "Remote:  %s (reused %v)\n", t.RemoteAddr, t.Reused)
+		}
+	}
+	var hops []string
+	for req := resp.Request; req.Response != nil && req.Response.Request != nil; req = req.Response.Request {
+		hops = append([]string{fmt.Sprintf("%s %s -> %d", req.Response.Request.Method, req.Response.Request.URL, req.Response.StatusCode)}, hops...)
+	}
+	for _, hop := range hops {
+		fmt.Fprintf(w, "Redirect: %s\n", hop)
+	}
+	fmt.Fprintf(w, "\n%s %s\n", resp.Request.Method, resp.Request.URL)
+	writeHeader(w, resp.Request.Header)
+	fmt.Fprintf(w, "\n%s %s\n", resp.Proto, resp.Status)
+	writeHeader(w, resp.Header)
+	return nil
+}
+
+// writeHeader はヘッダを名前の順に表示します.
+func writeHeader(w io.Writer, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, v)
+		}
+	}
+}
+
+// runCat は記録されたレスポンスのボディを出力します.
+//
+// Content-Encoding で圧縮されている場合は展開し テキストは UTF-8 に変換します.
+func runCat(c *cache.Cache, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+	raw := fs.Bool("raw", false, "output the stored body as is")
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	tx, ent, err := findEntry(c, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	resp, err := tx.Open(ent.Name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if *raw {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	if err = decodeBody(resp); err != nil {
+		return err
+	}
+	if !cache.IsText(resp.Header) {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	text, err := cache.ReadText(resp)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, text)
+	return err
+}
+
+// decodeBody は Content-Encoding に従ってボディを展開します.
+func decodeBody(resp *http.Response) error {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		r, err = zlib.NewReader(resp.Body)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{r, resp.Body}
+	return nil
+}
+
+// findEntry はトランザクション name から key に一致するエントリを探します.
+//
+// key にはエントリ名か URL を指定します.
+// 同じ URL のエントリが複数ある場合は最初に記録されたものを返します.
+func findEntry(c *cache.Cache, name, key string) (*cache.Tx, *cache.Entry, error) {
+	tx, err := c.GetTransaction(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	ents, err := tx.AllEntries()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range ents {
+		if ents[i].Name == key || ents[i].URL == key {
+			return tx, &ents[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("entry %q not found in transaction %q", key, name)
+}
@@ -0,0 +1,171 @@
+// crawlb コマンドは go-crawlb のキャッシュディレクトリを操作します.
+//
+// 使い方:
+//
+//	crawlb [-dir キャッシュディレクトリ] [-keyfile 鍵ファイル] コマンド [引数...]
+//
+// 暗号化したキャッシュディレクトリは -keyfile か環境変数 CRAWLB_KEYS で鍵を指定します.
+//
+// コマンド:
+//
+//	list    トランザクションの一覧を表示します
+//	entries トランザクションに記録されたリクエストの一覧を表示します
+//	show    記録されたリクエストとレスポンスの情報を表示します
+//	cat     記録されたレスポンスのボディを出力します
+//	diff    2 つのトランザクションの差分を表示します
+//	gc      保持ルールに従って古いトランザクションを破棄します
+//	fsck    キャッシュの整合性を検査します
+//	export  トランザクションをバンドルに出力します
+//	import  バンドルを取り込みます
+//	rotate  暗号化したキャッシュを先頭の鍵で暗号化し直します
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// command はサブコマンドを表します.
+type command struct {
+	usage string
+	run   func(c *cache.Cache, w io.Writer, args []string) error
+	// runStorage は Cache として開けない状態の保存先を扱うコマンドで run の代わりに使用します.
+	runStorage func(st cache.Storage, w io.Writer, args []string) error
+	// readOnly は引数から参照するだけかを判定し 参照するだけなら Cache を読み取り専用で開きます.
+	readOnly func(args []string) bool
+}
+
+// commands はサブコマンドの一覧です.
+var commands = map[string]command{
+	"list":    {"[-label label]", runList, nil, alwaysReadOnly},
+	"entries": {"tx", runEntries, nil, alwaysReadOnly},
+	"show":    {"tx entry|url", runShow, nil, alwaysReadOnly},
+	"cat":     {"[-raw] tx entry|url", runCat, nil, alwaysReadOnly},
+	"diff":    {"[-text] [-context n] [-header name] old new", runDiff, nil, alwaysReadOnly},
+	"gc":      {"[-n] [-keep n] [-age d] [-size bytes] [-daily n] [-weekly n]", runGc, nil, gcReadOnly},
+	"fsck":    {"[-repair | -quarantine]", runFsck, nil, nil},
+	"export":  {"-o file.tar.gz|file.zip tx...", runExport, nil, nil},
+	"import":  {"[-pin] file", runImport, nil, nil},
+	"rotate":  {"[-plain]", nil, runRotate, nil},
+}
+
+var errUsage = errors.New("usage")
+
+// alwaysReadOnly は常に参照するだけのコマンドの readOnly です.
+func alwaysReadOnly(args []string) bool { return true }
+
+func main() {
+	os.Exit(run(os.Stdout, os.Stderr, os.Args[1:]))
+}
+
+// run は crawlb コマンドを実行し 終了コードを返します.
+func run(stdout, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("crawlb", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", "_var/cache", "cache directory")
+	keyfile := fs.String("keyfile", "", "encryption key file (default $"+keysEnv+")")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: crawlb [-dir cachedir] [-keyfile file] command [args...]")
+		fs.PrintDefaults()
+		fmt.Fprintln(stderr, "commands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %s %s\n", name, commands[name].usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "crawlb: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	st, err := openStorage(*dir, *keyfile)
+	if err != nil {
+		fmt.Fprintln(stderr, "crawlb:", err)
+		return 1
+	}
+	if cmd.runStorage != nil {
+		err = cmd.runStorage(st, stdout, fs.Args()[1:])
+	} else {
+		err = runCache(st, cmd, stdout, fs.Args()[1:])
+	}
+	if err == errUsage {
+		fmt.Fprintf(stderr, "usage: crawlb %s %s\n", fs.Arg(0), cmd.usage)
+		return 2
+	} else if err != nil {
+		fmt.Fprintln(stderr, "crawlb:", err)
+		return 1
+	}
+	return 0
+}
+
+// keysEnv は暗号化の鍵を指定する環境変数名です.
+const keysEnv = "CRAWLB_KEYS"
+
+// openStorage はキャッシュディレクトリの保存先を開きます.
+//
+// 鍵ファイルか環境変数で鍵を指定した場合は暗号化した保存先として開きます.
+func openStorage(dir, keyfile string) (cache.Storage, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	var st cache.Storage = cache.NewDirStorage(dir)
+	var keys [][]byte
+	var err error
+	if keyfile != "" {
+		keys, err = cache.KeysFromFile(keyfile)
+	} else if _, ok := os.LookupEnv(keysEnv); ok {
+		keys, err = cache.KeysFromEnv(keysEnv)
+	}
+	if err != nil || keys == nil {
+		return st, err
+	}
+	return cache.NewEncryptedStorage(st, keys...)
+}
+
+// runCache は保存先を Cache として開いてコマンドを実行します.
+//
+// 参照するだけのコマンドは保存先に何も書き込まないよう読み取り専用で開きます.
+// それ以外のコマンドも開いただけで古いトランザクションを破棄しないよう 世代数に上限を設けません.
+func runCache(st cache.Storage, cmd command, w io.Writer, args []string) error {
+	var c *cache.Cache
+	var err error
+	if cmd.readOnly != nil && cmd.readOnly(args) {
+		c, err = cache.OpenReadOnly(st)
+	} else {
+		c, err = cache.NewWithStorage(st, math.MaxInt)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return cmd.run(c, w, args)
+}
+
+// parseFlags はサブコマンドのフラグを解析し 引数の数が n でなければ errUsage を返します.
+func parseFlags(fs *flag.FlagSet, args []string, n int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil || fs.NArg() != n {
+		return errUsage
+	}
+	return nil
+}
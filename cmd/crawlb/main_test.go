@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// newTestCache はテスト用のキャッシュディレクトリを作成し 2 つのトランザクションを記録します.
+func newTestCache(t *testing.T) (dir string, a, b *cache.Tx) {
+	t.Helper()
+	dir = t.TempDir()
+	c, err := cache.New(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := func(tx *cache.Tx, url, body string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		cf, err := tx.NewFile(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cf.Store(&http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, err = c.NewTransaction(cache.WithName("a")); err != nil {
+		t.Fatal(err)
+	}
+	store(a, "https://example.com/", "<p>old</p>\n")
+	if err = a.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = c.NewTransaction(cache.WithName("b")); err != nil {
+		t.Fatal(err)
+	}
+	store(b, "https://example.com/", "<p>new</p>\n")
+	store(b, "https://example.com/next", "next\n")
+	a.Release()
+	b.Release()
+	return dir, a, b
+}
+
+func TestDiffCommand(t *testing.T) {
+	dir, _, _ := newTestCache(t)
+
+	var stdout, stderr bytes.Buffer
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "diff", "-text", "a", "b"}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	got := stdout.String()
+	for _, want := range []string{
+		"~ GET https://example.com/\n",
+		"    -<p>old</p>\n    +<p>new</p>\n",
+		"+ GET https://example.com/next\n",
+		"2 changes\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s = %q, want to contain %q", "output", got, want)
+		}
+	}
+
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "diff", "a"}); code != 2 {
+		t.Errorf("%s = %d, want %d", "exit code", code, 2)
+	}
+}
+
+func TestInspectCommands(t *testing.T) {
+	dir, a, _ := newTestCache(t)
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"list"}, []string{"b ", "a ", "committed", "sealed"}},
+		{[]string{"entries", "b"}, []string{"GET     200", "https://example.com/next"}},
+		{[]string{"show", "a", "https://example.com/"}, []string{"GET https://example.com/", "Content-Type: text/html"}},
+		{[]string{"cat", "a", "https://example.com/"}, []string{"<p>old</p>\n"}},
+		{[]string{"gc", "-n", "-keep", "1"}, []string{"would discard a"}},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"-dir", dir}, tt.args...)
+		if code := run(&stdout, &stderr, args); code != 0 {
+			t.Errorf("%v: %s = %d, want %d: %s", tt.args, "exit code", code, 0, stderr.String())
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("%v: %s = %q, want to contain %q", tt.args, "output", stdout.String(), want)
+			}
+		}
+	}
+
+	// gc は保持ルールに従って破棄すること
+	var stdout, stderr bytes.Buffer
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "gc", "-keep", "1"}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	c, err := cache.New(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetTransaction(a.Name); err == nil {
+		t.Errorf("%s = %v, want error", "discarded transaction", err)
+	}
+}
+
+func TestReadOnlyCommands(t *testing.T) {
+	// 参照するだけのコマンドは保存先に何も書き込まないこと
+	dir := t.TempDir()
+	for _, args := range [][]string{{"list"}, {"entries", "a"}, {"show", "a", "x"}, {"cat", "a", "x"}, {"diff", "a", "b"}, {"gc", "-n", "-keep", "1"}} {
+		var stdout, stderr bytes.Buffer
+		run(&stdout, &stderr, append([]string{"-dir", dir}, args...))
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 0 {
+		t.Errorf("%s = %v, want empty", "files", ents)
+	}
+
+	// 参照中も使用中のトランザクションのロックを作成しないこと
+	dir, _, _ = newTestCache(t)
+	if err = os.RemoveAll(dir + "/.locks"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(dir + "/cache.json")
+	for _, args := range [][]string{{"cat", "a", "https://example.com/"}, {"gc", "-keep", "1", "-n"}} {
+		var stdout, stderr bytes.Buffer
+		if code := run(&stdout, &stderr, append([]string{"-dir", dir}, args...)); code != 0 {
+			t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+		}
+	}
+	after, _ := os.ReadFile(dir + "/cache.json")
+	if _, err = os.Stat(dir + "/.locks"); !os.IsNotExist(err) || !bytes.Equal(before, after) {
+		t.Errorf("%s = %v, want not exist and unchanged", ".locks", err)
+	}
+}
+
+func TestBundleCommands(t *testing.T) {
+	dir, _, _ := newTestCache(t)
+	bundle := t.TempDir() + "/b.zip"
+
+	var stdout, stderr bytes.Buffer
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "export", "-o", bundle, "b"}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	other := t.TempDir()
+	if code := run(&stdout, &stderr, []string{"-dir", other, "import", "-pin", bundle}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	if got, want := stdout.String(), "imported b\n"; got != want {
+		t.Errorf("%s = %q, want %q", "output", got, want)
+	}
+	stdout.Reset()
+	if code := run(&stdout, &stderr, []string{"-dir", other, "cat", "b", "https://example.com/next"}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	if got, want := stdout.String(), "next\n"; got != want {
+		t.Errorf("%s = %q, want %q", "output", got, want)
+	}
+}
+
+func TestFsckCommand(t *testing.T) {
+	dir, a, _ := newTestCache(t)
+	st := cache.NewDirStorage(dir)
+	st.Put(a.Name, strings.Repeat("f", 32), strings.NewReader("garbage"))
+
+	var stdout, stderr bytes.Buffer
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "fsck"}); code != 1 {
+		t.Errorf("%s = %d, want %d", "exit code", code, 1)
+	}
+	if want := "a/ffffffffffffffffffffffffffffffff: unreadable"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("%s = %q, want to contain %q", "output", stdout.String(), want)
+	}
+	stdout.Reset()
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "fsck", "-repair"}); code != 0 {
+		t.Errorf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "(removed)") {
+		t.Errorf("%s = %q, want to contain %q", "output", stdout.String(), "(removed)")
+	}
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "fsck"}); code != 0 {
+		t.Errorf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+}
+
+func TestRotateCommand(t *testing.T) {
+	dir, _, _ := newTestCache(t)
+	keyfile := t.TempDir() + "/key"
+	if err := os.WriteFile(keyfile, []byte(strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "-keyfile", keyfile, "rotate", "-plain"}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "re-encrypted ") {
+		t.Errorf("%s = %q, want %q", "output", stdout.String(), "re-encrypted ...")
+	}
+	stdout.Reset()
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "-keyfile", keyfile, "cat", "b", "https://example.com/next"}); code != 0 {
+		t.Fatalf("%s = %d, want %d: %s", "exit code", code, 0, stderr.String())
+	}
+	if got, want := stdout.String(), "next\n"; got != want {
+		t.Errorf("%s = %q, want %q", "output", got, want)
+	}
+	if code := run(&stdout, &stderr, []string{"-dir", dir, "list"}); code != 1 {
+		t.Errorf("%s = %d, want %d", "exit code without key", code, 1)
+	}
+}
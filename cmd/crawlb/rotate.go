@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+var errNotEncrypted = errors.New("cache is not encrypted: specify -keyfile or $" + keysEnv)
+
+// runRotate は暗号化したキャッシュを先頭の鍵で暗号化し直します.
+//
+// Cache として開く前に実行するため 暗号化していないキャッシュディレクトリも暗号化できます.
+func runRotate(st cache.Storage, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	plain := fs.Bool("plain", false, "also encrypt unencrypted entries")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	est, ok := st.(*cache.EncryptedStorage)
+	if !ok {
+		return errNotEncrypted
+	}
+	n, err := est.Rotate(*plain)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "re-encrypted %d files\n", n)
+	return nil
+}
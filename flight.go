@@ -0,0 +1,69 @@
+package crawlb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// flight は実行中の fetchAndStore を表します.
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// flightGroup は同じリクエストの fetchAndStore を 1 つにまとめます.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// fetchAndStore は同じトランザクションで同じリクエストを同時に発行した場合
+// 最初の 1 つだけがサーバにアクセスし 残りはその完了を待ちます.
+//
+// キャッシュファイルの識別子をキーにまとめるため
+// 待っていた呼び出し元はそれぞれキャッシュファイルを読み込み 独立したボディを得られます.
+// 最初の呼び出し元がキャンセルされて失敗した場合は 待っていた呼び出し元が改めて取得します.
+func (g *flightGroup) fetchAndStore(cl *Client, cf *cache.File, req *http.Request) error {
+	key := cl.tx.Name + "/" + cf.Name()
+	ctx := req.Context()
+
+	for {
+		g.mu.Lock()
+		f, ok := g.flights[key]
+		if !ok {
+			break
+		}
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !isCanceled(f.err) || ctx.Err() != nil {
+			return f.err
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.err = cl.fetchAndStore(cf, req)
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+// isCanceled は err が呼び出し元の context によるエラーかを返します.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,67 @@
+package crawlb
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// FreshnessRule は URL のパターンに一致するエントリの有効期間を表します.
+//
+// 株価の一覧や時刻表など すぐに古くなるページに有効期間を設定すると
+// 同じトランザクションの中でも有効期間を過ぎたエントリを取得し直します.
+type FreshnessRule struct {
+	Host string        // ホスト名のパターン (path.Match 形式). 空の場合は全てのホストに一致
+	Path string        // パスのパターン (path.Match 形式). 末尾が / の場合はその下の全てのパスに一致
+	TTL  time.Duration // 有効期間
+}
+
+// match は u がパターンに一致するかを返します.
+func (r *FreshnessRule) match(u *url.URL) bool {
+	if r.Host != "" {
+		if ok, _ := path.Match(r.Host, u.Hostname()); !ok {
+			return false
+		}
+	}
+	if r.Path == "" {
+		return true
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if strings.HasSuffix(r.Path, "/") {
+		return strings.HasPrefix(p, r.Path)
+	}
+	ok, _ := path.Match(r.Path, p)
+	return ok
+}
+
+// WithFreshness は URL のパターン毎にエントリの有効期間を指定します.
+//
+// rules は先頭から順に照合し 最初に一致したものを使用します.
+// どれにも一致しないエントリはこれまで通りトランザクションに記録された結果を使い続けます.
+// 封印されたトランザクションでは有効期間を過ぎても記録された結果を返します.
+func WithFreshness(rules ...FreshnessRule) Option {
+	return func(cl *Client) {
+		cl.rules = append(cl.rules, rules...)
+	}
+}
+
+// expired はキャッシュファイルが有効期間を過ぎているかを返します.
+func (cl *Client) expired(cf *cache.File, u *url.URL) (bool, error) {
+	for i := range cl.rules {
+		if !cl.rules[i].match(u) {
+			continue
+		}
+		ent, ok, err := cf.Entry()
+		if err != nil || !ok {
+			return false, err
+		}
+		return time.Since(ent.FetchAt) >= cl.rules[i].TTL, nil
+	}
+	return false, nil
+}
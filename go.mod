@@ -4,7 +4,7 @@ go 1.20
 
 require (
 	github.com/17e10/go-httpb v0.1.0
-	github.com/17e10/go-jsonb v0.1.0
 	github.com/17e10/go-notifyb v0.1.0
+	golang.org/x/sys v0.10.0
 	golang.org/x/text v0.11.0
 )
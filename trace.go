@@ -0,0 +1,99 @@
+package crawlb
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+// tracer は httptrace を使ってリクエストの所要時間と接続の情報を計測します.
+//
+// リダイレクトした場合 名前解決や接続に掛かった時間は合算します.
+type tracer struct {
+	mu        sync.Mutex
+	t         *cache.Timing
+	start     time.Time
+	dnsStart  time.Time
+	connStart time.Time
+	tlsStart  time.Time
+}
+
+// newTracer は mutex の待ち時間 wait を記録した新しい tracer を作成し 計測を開始します.
+func newTracer(wait time.Duration) *tracer {
+	return &tracer{t: &cache.Timing{Wait: wait}, start: time.Now()}
+}
+
+// withTrace は計測する httptrace.ClientTrace を設定したリクエストの複製を返します.
+func (tr *tracer) withTrace(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.update(func() { tr.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tr.update(func() { tr.t.DNS += time.Since(tr.dnsStart) })
+		},
+		ConnectStart: func(network, addr string) {
+			tr.update(func() { tr.connStart = time.Now() })
+		},
+		ConnectDone: func(network, addr string, err error) {
+			tr.update(func() { tr.t.Connect += time.Since(tr.connStart) })
+		},
+		TLSHandshakeStart: func() {
+			tr.update(func() { tr.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tr.update(func() { tr.t.TLS += time.Since(tr.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.update(func() {
+				tr.t.Reused = info.Reused
+				if addr := info.Conn.RemoteAddr(); addr != nil {
+					tr.t.RemoteAddr = addr.String()
+				}
+			})
+		},
+		GotFirstResponseByte: func() {
+			tr.update(func() { tr.t.FirstByte = time.Since(tr.start) })
+		},
+	}))
+}
+
+// update は排他制御して fn を実行します.
+//
+// 名前解決や接続は並行して試行されることがあるためです.
+func (tr *tracer) update(fn func()) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	fn()
+}
+
+// timing は計測結果を返します.
+//
+// ボディを読み終えた時点で Total を更新するよう resp.Body を置き換えます.
+func (tr *tracer) timing(resp *http.Response) *cache.Timing {
+	resp.Body = &timedBody{resp.Body, func() {
+		tr.update(func() { tr.t.Total = time.Since(tr.start) })
+	}}
+	return tr.t
+}
+
+// timedBody はボディを読み終えた時に done を呼び出す io.ReadCloser です.
+type timedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+// Read はボディを読み込み 読み終えた時に done を呼び出します.
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && b.done != nil {
+		b.done()
+		b.done = nil
+	}
+	return n, err
+}
@@ -0,0 +1,220 @@
+package crawlb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/17e10/go-crawlb/cache"
+	"github.com/17e10/go-httpb"
+)
+
+// DefaultWatchLabel は Watcher が作成するトランザクションに付ける既定のラベルです.
+const DefaultWatchLabel = "watch"
+
+// PageChange は Watcher が検出したページの変更を表します.
+type PageChange struct {
+	URL   string `json:"url"`
+	OldTx string `json:"old_tx"` // 前回のトランザクション名
+	NewTx string `json:"new_tx"` // 今回のトランザクション名
+	Old   []byte `json:"old"`    // 前回の内容 (Extract を適用した結果)
+	New   []byte `json:"new"`    // 今回の内容 (Extract を適用した結果)
+}
+
+// Notifier は Watcher が検出した変更の通知先を表すインターフェイスです.
+type Notifier interface {
+	Notify(ch *PageChange) error
+}
+
+// NotifierFunc は通常の関数を Notifier に変換するアダプタです.
+type NotifierFunc func(*PageChange) error
+
+// Notify は fn(ch) を呼び出します.
+func (fn NotifierFunc) Notify(ch *PageChange) error {
+	return fn(ch)
+}
+
+// WebhookNotifier は変更を JSON 形式で URL に POST します.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client // nil の場合は http.DefaultClient
+}
+
+// Notify は変更を JSON 形式で URL に POST します.
+func (n *WebhookNotifier) Notify(ch *PageChange) error {
+	b, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	hc := n.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Post(n.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return httpb.ErrStatus(resp)
+	}
+	return nil
+}
+
+// LogNotifier は変更をログファイルに追記します.
+type LogNotifier struct {
+	Name string
+}
+
+// Notify は変更をログファイルに 1 行追記します.
+func (n *LogNotifier) Notify(ch *PageChange) error {
+	f, err := os.OpenFile(n.Name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s changed %s (%s -> %s)\n",
+		time.Now().Format("2006-01-02 15:04:05"), ch.URL, ch.OldTx, ch.NewTx)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Watcher は指定したページを巡回し 前回から変更されたページを通知します.
+//
+// Watch を呼び出す毎に新しいトランザクションを作成して URLs を取得し
+// 前回 Watch でコミットしたトランザクションとボディを比較します.
+// 前回のトランザクションに無いページは比較の基準がないため通知しません.
+type Watcher struct {
+	// Client は巡回に使用する Client です.
+	// Watch は Client と Cache やアクセス間隔を共有する別の Client でトランザクションを作成するため
+	// Client が使用中のトランザクションは切り替わりません.
+	Client    *Client
+	URLs      []string
+	Label     string     // トランザクションに付けるラベル. 空の場合は DefaultWatchLabel
+	Notifiers []Notifier // 変更の通知先
+
+	// Extract はボディから比較する部分を取り出します.
+	// 更新日時や広告など変更として扱いたくない部分を取り除く場合に使用します.
+	// nil の場合はボディ全体を比較します.
+	Extract func(url string, body []byte) ([]byte, error)
+}
+
+// Watch はページを巡回して変更を検出し 通知先に通知します.
+//
+// 検出した変更を返します. 巡回に失敗した場合はトランザクションを中断します.
+// 通知に失敗した通知先があっても残りの通知先と変更への通知を続け 全てのエラーをまとめて返します.
+func (w *Watcher) Watch() ([]PageChange, error) {
+	cl := w.Client.clone()
+	label := w.Label
+	if label == "" {
+		label = DefaultWatchLabel
+	}
+
+	// 前回のトランザクションは比較を終えるまで破棄されないよう使用中にする
+	var prev *cache.Tx
+	found, err := cl.cache.Find(cache.Committed, cache.HasLabel(label))
+	if err != nil {
+		return nil, err
+	}
+	if len(found) > 0 {
+		if prev, err = cl.cache.GetTransaction(found[0].Name); err != nil {
+			return nil, err
+		}
+		defer prev.Release()
+	}
+	if err = cl.NewTransaction(cache.WithLabels(label)); err != nil {
+		return nil, err
+	}
+	defer cl.tx.Release()
+
+	changes, err := w.scan(cl, prev)
+	if err != nil {
+		cl.Abort()
+		return nil, err
+	}
+	if err = cl.Commit(); err != nil {
+		return nil, err
+	}
+
+	// 通知に失敗しても次回は今回のトランザクションと比較するため 全ての変更を全ての通知先に通知する
+	var errs []error
+	for i := range changes {
+		for _, n := range w.Notifiers {
+			if err = n.Notify(&changes[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return changes, errors.Join(errs...)
+}
+
+// scan は cl でページを取得して前回のトランザクションと比較します.
+func (w *Watcher) scan(cl *Client, prev *cache.Tx) ([]PageChange, error) {
+	var changes []PageChange
+	for _, url := range w.URLs {
+		resp, err := cl.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		cur, err := w.extract(url, resp)
+		if err != nil {
+			return nil, err
+		}
+		if prev == nil {
+			continue
+		}
+
+		old, ok, err := w.load(cl, prev, url)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !bytes.Equal(old, cur) {
+			changes = append(changes, PageChange{url, prev.Name, cl.tx.Name, old, cur})
+		}
+	}
+	return changes, nil
+}
+
+// load は前回のトランザクションから url に対する内容を読み込みます.
+//
+// リダイレクトされた場合 resp.Request は最後のリクエストになるため
+// 巡回した url から改めてリクエストを作ります.
+func (w *Watcher) load(cl *Client, prev *cache.Tx, url string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(cl.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	cf, err := prev.NewFile(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if !cf.IsExists() {
+		return nil, false, nil
+	}
+	resp, err := cf.Load()
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := w.extract(url, resp)
+	return b, err == nil, err
+}
+
+// extract はレスポンスのボディを読み込み 比較する部分を取り出します.
+func (w *Watcher) extract(url string, resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if w.Extract == nil {
+		return b, nil
+	}
+	return w.Extract(url, b)
+}
@@ -0,0 +1,113 @@
+package crawlb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/17e10/go-crawlb/cache"
+)
+
+var errNotify = errors.New("notify failed")
+
+func TestWatcher(t *testing.T) {
+	pages := map[string]string{
+		"/notice":   "notice v1",
+		"/schedule": "schedule v1 <time>1</time>",
+		"/new":      "moved v1",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer ts.Close()
+
+	var hooked []PageChange
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ch PageChange
+		json.NewDecoder(r.Body).Decode(&ch)
+		hooked = append(hooked, ch)
+	}))
+	defer hook.Close()
+
+	cl, err := NewClient(context.TODO(), 0, "", 0, WithCache(cache.NewMemory(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events TestEvents
+	logname := filepath.Join(t.TempDir(), "watch.log")
+	timeRe := regexp.MustCompile(`<time>.*</time>`)
+	w := &Watcher{
+		Client: cl,
+		URLs:   []string{ts.URL + "/notice", ts.URL + "/schedule", ts.URL + "/old"},
+		Notifiers: []Notifier{
+			// 失敗する通知先があっても残りの通知先に通知すること
+			NotifierFunc(func(ch *PageChange) error {
+				return errNotify
+			}),
+			NotifierFunc(func(ch *PageChange) error {
+				events.Addf("%s: %s -> %s", strings.TrimPrefix(ch.URL, ts.URL), ch.Old, ch.New)
+				return nil
+			}),
+			&WebhookNotifier{URL: hook.URL},
+			&LogNotifier{Name: logname},
+		},
+		Extract: func(url string, body []byte) ([]byte, error) {
+			return timeRe.ReplaceAll(body, nil), nil
+		},
+	}
+
+	// 呼び出し元が使用中のトランザクションは切り替えないこと
+	if err = cl.NewTransaction(cache.WithName("mine")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 初回は比較の基準がないため通知しない
+	if _, err = w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	// 取り除いた部分だけの変更は通知しない
+	pages["/schedule"] = "schedule v1 <time>2</time>"
+	if _, err = w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	pages["/notice"] = "notice v2"
+	// リダイレクトされるページも巡回した URL で比較すること
+	pages["/new"] = "moved v2"
+	changes, err := w.Watch()
+	if !errors.Is(err, errNotify) {
+		t.Fatalf("%s = %v, want %v", "Watch", err, errNotify)
+	}
+
+	if cl.tx.Name != "mine" || cl.tx.IsSealed() || cl.tx.State() != cache.StatusOpen {
+		t.Errorf("%s = %+v, want %q", "client transaction", cl.tx, "mine")
+	}
+	if len(changes) != 2 {
+		t.Fatalf("%s = %d, want %d", "len(changes)", len(changes), 2)
+	}
+	want := []string{"/notice: notice v1 -> notice v2", "/old: moved v1 -> moved v2"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("%s = %v, want %v", "events", events, want)
+	}
+	if len(hooked) != 2 || string(hooked[0].New) != "notice v2" {
+		t.Errorf("%s = %v", "webhook", hooked)
+	}
+	b, err := os.ReadFile(logname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "changed "+ts.URL+"/notice") {
+		t.Errorf("%s = %q", "log", b)
+	}
+}